@@ -0,0 +1,276 @@
+package erl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ryhazerus/erl/store"
+)
+
+// ErrCircuitOpen is returned when a request is refused by a resource's open
+// circuit breaker.
+var ErrCircuitOpen = errors.New("erl: circuit open")
+
+// CircuitOpenError reports which resource's breaker refused a request and
+// when it will next let a probe through.
+type CircuitOpenError struct {
+	Resource Resource
+	RetryAt  time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("erl: circuit open for %s", e.Resource.Name)
+}
+
+func (e *CircuitOpenError) Unwrap() error {
+	return ErrCircuitOpen
+}
+
+// BreakerState is the state of a resource's circuit breaker.
+type BreakerState int
+
+const (
+	// BreakerClosed lets requests through and counts their outcomes.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen refuses requests until OpenFor has elapsed.
+	BreakerOpen
+	// BreakerHalfOpen lets a limited number of probe requests through; a
+	// success closes the breaker and a failure opens it again.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "Closed"
+	case BreakerOpen:
+		return "Open"
+	case BreakerHalfOpen:
+		return "HalfOpen"
+	default:
+		return "Unknown"
+	}
+}
+
+// BreakerPolicy configures a resource's circuit breaker. Transport errors
+// and 429 or 5xx responses count as failures.
+type BreakerPolicy struct {
+	FailureRatio     float64       // trip when failures/requests reaches this ratio; default 0.5
+	MinRequests      int64         // requests needed in Interval before tripping; default 10
+	Interval         time.Duration // period over which outcomes are counted; default 1 minute
+	OpenFor          time.Duration // time spent open before probing; default 30 seconds
+	HalfOpenRequests int64         // probes allowed while half-open; default 1
+
+	// Shared counts outcomes in the limiter's store instead of in memory,
+	// so every instance sharing the store trips together. This costs two
+	// extra store reads per request while the breaker is closed.
+	Shared bool
+}
+
+func (p BreakerPolicy) withDefaults() BreakerPolicy {
+	if p.FailureRatio <= 0 {
+		p.FailureRatio = 0.5
+	}
+	if p.MinRequests <= 0 {
+		p.MinRequests = 10
+	}
+	if p.Interval <= 0 {
+		p.Interval = time.Minute
+	}
+	if p.OpenFor <= 0 {
+		p.OpenFor = 30 * time.Second
+	}
+	if p.HalfOpenRequests <= 0 {
+		p.HalfOpenRequests = 1
+	}
+	return p
+}
+
+// breaker is the per-instance state machine of a resource's circuit breaker.
+type breaker struct {
+	policy BreakerPolicy
+
+	mu       sync.Mutex
+	state    BreakerState
+	openedAt time.Time
+	probes   int64 // probes let through while half-open
+}
+
+func newBreaker(p BreakerPolicy) *breaker {
+	return &breaker{policy: p.withDefaults()}
+}
+
+// failed reports whether an upstream exchange counts as a failure.
+func failed(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// breakerKeys returns the store keys and window counting a breaker's
+// requests and failures in the interval containing now.
+func breakerKeys(name string, p BreakerPolicy, now time.Time) (requests, failures string, w store.Window) {
+	start := now.Truncate(p.Interval)
+	w = store.Window{
+		Duration:    p.Interval,
+		BucketKey:   strconv.FormatInt(start.UnixNano(), 10),
+		BucketStart: start,
+	}
+	key := counterKey(name, "")
+	return key + "#breaker-requests", key + "#breaker-failures", w
+}
+
+// breakerStore returns the store holding a breaker's outcome counters.
+func (l *Limiter) breakerStore(p BreakerPolicy) store.Store {
+	if p.Shared {
+		return l.store
+	}
+	return l.local
+}
+
+// breakerAllow decides whether a request to r may proceed, and whether it is
+// one of the probes of a half-open breaker. When it returns nil, the caller
+// must report the outcome with breakerRecord, or call breakerRelease if the
+// request never reached the upstream, passing probe back to either.
+func (l *Limiter) breakerAllow(ctx context.Context, r Resource, b *breaker) (probe bool, err error) {
+	now := time.Now()
+	p := b.policy
+
+	b.mu.Lock()
+	state := b.state
+	b.mu.Unlock()
+
+	if state == BreakerClosed && p.Shared {
+		// Another instance may have seen the upstream fail.
+		s := l.breakerStore(p)
+		reqKey, failKey, w := breakerKeys(r.Name, p, now)
+		requests, err := s.Get(ctx, reqKey, w)
+		if err != nil {
+			return false, fmt.Errorf("erl: store error: %w", err)
+		}
+		failures, err := s.Get(ctx, failKey, w)
+		if err != nil {
+			return false, fmt.Errorf("erl: store error: %w", err)
+		}
+		if tripped(p, requests, failures) {
+			l.breakerTransition(r, b, BreakerClosed, BreakerOpen, now)
+		}
+	}
+
+	b.mu.Lock()
+	from := b.state
+	switch b.state {
+	case BreakerClosed:
+		b.mu.Unlock()
+		return false, nil
+	case BreakerOpen:
+		if now.Before(b.openedAt.Add(p.OpenFor)) {
+			retryAt := b.openedAt.Add(p.OpenFor)
+			b.mu.Unlock()
+			return false, &CircuitOpenError{Resource: r, RetryAt: retryAt}
+		}
+		b.state, b.probes = BreakerHalfOpen, 1
+		b.mu.Unlock()
+		l.notifyBreaker(r, from, BreakerHalfOpen)
+		return true, nil
+	default: // BreakerHalfOpen
+		if b.probes >= p.HalfOpenRequests {
+			b.mu.Unlock()
+			return false, &CircuitOpenError{Resource: r, RetryAt: now.Add(p.OpenFor)}
+		}
+		b.probes++
+		b.mu.Unlock()
+		return true, nil
+	}
+}
+
+// breakerRelease returns the half-open probe slot of a request that was not
+// sent upstream.
+func (l *Limiter) breakerRelease(b *breaker, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if probe && b.state == BreakerHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+// breakerRecord reports the outcome of a request allowed by breakerAllow.
+// Only probes decide a half-open breaker; requests admitted while it was
+// closed are counted only if it still is.
+func (l *Limiter) breakerRecord(ctx context.Context, r Resource, b *breaker, probe, fail bool) {
+	now := time.Now()
+	p := b.policy
+	s := l.breakerStore(p)
+	reqKey, failKey, w := breakerKeys(r.Name, p, now)
+
+	if probe {
+		if fail {
+			l.breakerTransition(r, b, BreakerHalfOpen, BreakerOpen, now)
+			return
+		}
+		// Start the closed state with a clean slate, here and on any
+		// instance sharing the counters.
+		s.Reset(ctx, reqKey)
+		s.Reset(ctx, failKey)
+		l.breakerTransition(r, b, BreakerHalfOpen, BreakerClosed, now)
+		return
+	}
+	if b.current() != BreakerClosed {
+		return
+	}
+
+	var failDelta int64
+	if fail {
+		failDelta = 1
+	}
+	counts, err := l.incrementAllIn(ctx, s, []store.Increment{
+		{Key: reqKey, Window: w, Delta: 1},
+		{Key: failKey, Window: w, Delta: failDelta},
+	})
+	// Outcomes that cannot be recorded are dropped; the breaker errs on the
+	// side of letting traffic through.
+	if err == nil && tripped(p, counts[0], counts[1]) {
+		l.breakerTransition(r, b, BreakerClosed, BreakerOpen, now)
+	}
+}
+
+// tripped reports whether the outcome counts call for opening the breaker.
+func tripped(p BreakerPolicy, requests, failures int64) bool {
+	return requests >= p.MinRequests && float64(failures) >= p.FailureRatio*float64(requests)
+}
+
+// breakerTransition moves b from one state to another if it is still in the
+// expected state, and notifies the callback.
+func (l *Limiter) breakerTransition(r Resource, b *breaker, from, to BreakerState, now time.Time) {
+	b.mu.Lock()
+	if b.state != from {
+		b.mu.Unlock()
+		return
+	}
+	b.state, b.probes = to, 0
+	if to == BreakerOpen {
+		b.openedAt = now
+	}
+	b.mu.Unlock()
+
+	l.notifyBreaker(r, from, to)
+}
+
+func (l *Limiter) notifyBreaker(r Resource, from, to BreakerState) {
+	if l.onBreakerChange != nil {
+		l.onBreakerChange(r, from, to)
+	}
+}
+
+// current returns the breaker's state.
+func (b *breaker) current() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
@@ -0,0 +1,146 @@
+package erl
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ryhazerus/erl/store"
+)
+
+func TestTransportBreaker(t *testing.T) {
+	var hits atomic.Int64
+	var status atomic.Int64
+	status.Store(http.StatusInternalServerError)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer srv.Close()
+
+	var transitions []string
+	l := New(WithOnBreakerStateChange(func(r Resource, from, to BreakerState) {
+		transitions = append(transitions, from.String()+"->"+to.String())
+	}))
+	l.Register(Resource{
+		Name:    "flaky",
+		Pattern: "*",
+		Limit:   100,
+		Window:  PerMinute,
+		Breaker: &BreakerPolicy{MinRequests: 4, FailureRatio: 0.5, OpenFor: 50 * time.Millisecond},
+	})
+	client := &http.Client{Transport: l.Transport(nil)}
+
+	for i := 0; i < 4; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+		resp.Body.Close()
+	}
+
+	_, err := client.Get(srv.URL)
+	var openErr *CircuitOpenError
+	if !errors.As(err, &openErr) || !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected *CircuitOpenError, got %v", err)
+	}
+	if hits.Load() != 4 {
+		t.Errorf("upstream hits = %d, want 4", hits.Load())
+	}
+
+	statuses, _ := l.Snapshot(context.Background())
+	if statuses[0].Breaker != BreakerOpen {
+		t.Errorf("snapshot breaker = %s, want Open", statuses[0].Breaker)
+	}
+
+	// After OpenFor a successful probe closes the breaker.
+	time.Sleep(60 * time.Millisecond)
+	status.Store(http.StatusOK)
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	resp.Body.Close()
+
+	want := []string{"Closed->Open", "Open->HalfOpen", "HalfOpen->Closed"}
+	if len(transitions) != len(want) {
+		t.Fatalf("transitions = %v, want %v", transitions, want)
+	}
+	for i := range want {
+		if transitions[i] != want[i] {
+			t.Errorf("transition %d = %s, want %s", i, transitions[i], want[i])
+		}
+	}
+}
+
+func TestTransportBreakerShared(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	s := store.NewMemoryStore()
+	r := Resource{
+		Name:    "shared-flaky",
+		Pattern: "*",
+		Limit:   100,
+		Window:  PerMinute,
+		Breaker: &BreakerPolicy{MinRequests: 2, OpenFor: time.Minute, Shared: true},
+	}
+	a := New(WithStore(s))
+	b := New(WithStore(s))
+	a.Register(r)
+	b.Register(r)
+
+	ca := &http.Client{Transport: a.Transport(nil)}
+	cb := &http.Client{Transport: b.Transport(nil)}
+
+	for i := 0; i < 2; i++ {
+		resp, err := ca.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+		resp.Body.Close()
+	}
+
+	// The second instance has not sent anything but backs off too.
+	if _, err := cb.Get(srv.URL); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen on the second instance, got %v", err)
+	}
+}
+
+func TestBreakerProbeDecidedAtAdmission(t *testing.T) {
+	l := New()
+	r := Resource{Name: "flaky", Breaker: &BreakerPolicy{MinRequests: 1, OpenFor: 10 * time.Millisecond}}
+	b := newBreaker(*r.Breaker)
+	ctx := context.Background()
+
+	// A slow request is let through while the breaker is closed.
+	slow, err := l.breakerAllow(ctx, r, b)
+	if err != nil || slow {
+		t.Fatalf("slow request: probe = %v, err = %v", slow, err)
+	}
+
+	// Another one fails and trips the breaker, which then half-opens.
+	failing, _ := l.breakerAllow(ctx, r, b)
+	l.breakerRecord(ctx, r, b, failing, true)
+	time.Sleep(10 * time.Millisecond)
+	probe, err := l.breakerAllow(ctx, r, b)
+	if err != nil || !probe {
+		t.Fatalf("probe: probe = %v, err = %v", probe, err)
+	}
+
+	// The slow request finishing is not the probe's outcome.
+	l.breakerRecord(ctx, r, b, slow, false)
+	if got := b.current(); got != BreakerHalfOpen {
+		t.Fatalf("after slow request: state = %s, want HalfOpen", got)
+	}
+	l.breakerRecord(ctx, r, b, probe, false)
+	if got := b.current(); got != BreakerClosed {
+		t.Errorf("after probe: state = %s, want Closed", got)
+	}
+}
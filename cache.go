@@ -0,0 +1,292 @@
+package erl
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CachePolicy enables a private HTTP response cache for a resource inside
+// Limiter.Transport. Responses are cached per partition, or per
+// Authorization header on unpartitioned resources. Fresh responses are served from the cache without
+// charging the resource's quota. Freshness follows RFC 9111: Cache-Control
+// max-age, Expires and, for heuristically cacheable statuses, the
+// Last-Modified heuristic.
+type CachePolicy struct {
+	MaxEntries int           // maximum number of cached responses; 0 means no limit
+	MaxBytes   int64         // maximum total size of cached bodies; 0 means no limit
+	DefaultTTL time.Duration // freshness when the response gives no explicit lifetime
+}
+
+// heuristicStatus lists the status codes that are heuristically cacheable
+// (RFC 9110 §15.1).
+var heuristicStatus = map[int]bool{
+	200: true, 203: true, 204: true, 206: true, 300: true, 301: true, 308: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+type cacheEntry struct {
+	key          string
+	status       int
+	header       http.Header
+	body         []byte
+	vary         map[string]string // request header values the response varies on
+	lifetime     time.Duration
+	initialAge   time.Duration
+	responseTime time.Time
+}
+
+// age returns the entry's current age (RFC 9111 §4.2.3).
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	return e.initialAge + now.Sub(e.responseTime)
+}
+
+// responseCache is an LRU cache of responses for one resource.
+type responseCache struct {
+	policy CachePolicy
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // front is most recently used
+	size    int64
+}
+
+func newResponseCache(p CachePolicy) *responseCache {
+	return &responseCache{
+		policy:  p,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// cacheKey identifies a cached response. The partition, or the credentials
+// on unpartitioned resources, keep clients from seeing each other's
+// responses (RFC 9111 §3.5).
+func cacheKey(partition string, req *http.Request) string {
+	return responseScope(partition, req) + " " + req.URL.String()
+}
+
+// get returns a fresh cached response for req, or nil.
+func (c *responseCache) get(key string, req *http.Request, now time.Time) *http.Response {
+	if req.Method != http.MethodGet {
+		return nil
+	}
+	reqCC := parseCacheControl(req.Header)
+	if reqCC.has("no-store") || reqCC.has("no-cache") || req.Header.Get("Pragma") == "no-cache" {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*cacheEntry)
+
+	for name, v := range e.vary {
+		if req.Header.Get(name) != v {
+			return nil
+		}
+	}
+
+	age := e.age(now)
+	lifetime := e.lifetime
+	if v, ok := reqCC.seconds("max-age"); ok && v < lifetime {
+		lifetime = v
+	}
+	if v, ok := reqCC.seconds("min-fresh"); ok {
+		lifetime -= v
+	}
+	if age >= lifetime {
+		return nil
+	}
+
+	c.lru.MoveToFront(el)
+
+	header := e.header.Clone()
+	header.Set("Age", strconv.FormatInt(int64(age/time.Second), 10))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.status, http.StatusText(e.status)),
+		StatusCode:    e.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(e.body)),
+		ContentLength: int64(len(e.body)),
+		Request:       req,
+	}
+}
+
+// store caches resp for req if RFC 9111 allows it. Responses to unsafe
+// methods invalidate the cached entry instead. resp.Body is replaced with an
+// equivalent unread body.
+func (c *responseCache) store(key string, req *http.Request, resp *http.Response, requestTime, now time.Time) error {
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return nil
+	default:
+		if resp.StatusCode < 400 {
+			c.remove(key)
+		}
+		return nil
+	}
+
+	reqCC := parseCacheControl(req.Header)
+	respCC := parseCacheControl(resp.Header)
+	if reqCC.has("no-store") || respCC.has("no-store") || respCC.has("no-cache") {
+		return nil
+	}
+
+	vary := make(map[string]string)
+	for _, v := range resp.Header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "*" {
+				return nil
+			}
+			if name != "" {
+				vary[name] = req.Header.Get(name)
+			}
+		}
+	}
+
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		date = now
+	}
+	lifetime, ok := c.lifetime(resp, respCC, date)
+	if !ok || lifetime <= 0 {
+		return nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if c.policy.MaxBytes > 0 && int64(len(body)) > c.policy.MaxBytes {
+		return nil
+	}
+
+	// Initial age per RFC 9111 §4.2.3.
+	apparentAge := max(0, now.Sub(date))
+	ageValue, _ := strconv.ParseInt(resp.Header.Get("Age"), 10, 64)
+	correctedAge := time.Duration(ageValue)*time.Second + now.Sub(requestTime)
+
+	c.put(&cacheEntry{
+		key:          key,
+		status:       resp.StatusCode,
+		header:       resp.Header.Clone(),
+		body:         body,
+		vary:         vary,
+		lifetime:     lifetime,
+		initialAge:   max(apparentAge, correctedAge),
+		responseTime: now,
+	})
+	return nil
+}
+
+// lifetime returns the freshness lifetime of a response (RFC 9111 §4.2.1).
+func (c *responseCache) lifetime(resp *http.Response, cc cacheControl, date time.Time) (time.Duration, bool) {
+	if v, ok := cc.seconds("max-age"); ok {
+		return v, true
+	}
+	if h := resp.Header.Get("Expires"); h != "" {
+		expires, err := http.ParseTime(h)
+		if err != nil {
+			// Invalid Expires means already expired.
+			return 0, false
+		}
+		return expires.Sub(date), true
+	}
+
+	if !heuristicStatus[resp.StatusCode] && !cc.has("public") {
+		return 0, false
+	}
+	if lm, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil && date.After(lm) {
+		return date.Sub(lm) / 10, true
+	}
+	if c.policy.DefaultTTL > 0 {
+		return c.policy.DefaultTTL, true
+	}
+	return 0, false
+}
+
+func (c *responseCache) put(e *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[e.key]; ok {
+		c.size -= int64(len(el.Value.(*cacheEntry).body))
+		c.lru.Remove(el)
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	c.size += int64(len(e.body))
+
+	for c.lru.Len() > 0 &&
+		(c.policy.MaxEntries > 0 && c.lru.Len() > c.policy.MaxEntries ||
+			c.policy.MaxBytes > 0 && c.size > c.policy.MaxBytes) {
+		c.evict(c.lru.Back())
+	}
+}
+
+func (c *responseCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.evict(el)
+	}
+}
+
+// evict removes el. The caller must hold c.mu.
+func (c *responseCache) evict(el *list.Element) {
+	e := c.lru.Remove(el).(*cacheEntry)
+	delete(c.entries, e.key)
+	c.size -= int64(len(e.body))
+}
+
+// cacheControl holds parsed Cache-Control directives.
+type cacheControl map[string]string
+
+func parseCacheControl(h http.Header) cacheControl {
+	cc := make(cacheControl)
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(d), "=")
+			if name == "" {
+				continue
+			}
+			cc[strings.ToLower(name)] = strings.Trim(value, `"`)
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+func (cc cacheControl) seconds(name string) (time.Duration, bool) {
+	v, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
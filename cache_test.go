@@ -0,0 +1,205 @@
+package erl
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newCachedTestServer(t *testing.T, cacheControl string) (*httptest.Server, *int) {
+	t.Helper()
+	hits := new(int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*hits++
+		if cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		w.Header().Set("Vary", "Accept-Language")
+		w.Write([]byte("hello " + r.Header.Get("Accept-Language")))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, hits
+}
+
+func TestTransportCache(t *testing.T) {
+	srv, hits := newCachedTestServer(t, "max-age=60")
+
+	l := New()
+	l.Register(Resource{
+		Name:     "geo",
+		Pattern:  "*",
+		Limit:    1,
+		Window:   PerMinute,
+		Strategy: Block,
+		Price:    Units(0.005),
+		Cache:    &CachePolicy{MaxEntries: 10},
+	})
+	client := &http.Client{Transport: l.Transport(nil)}
+
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(srv.URL + "/geocode")
+		if err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "hello " {
+			t.Errorf("request %d: body = %q", i+1, body)
+		}
+	}
+
+	if *hits != 1 {
+		t.Errorf("upstream hits = %d, want 1", *hits)
+	}
+
+	statuses, _ := l.Snapshot(context.Background())
+	st := statuses[0]
+	if st.Current != 1 || st.CacheHits != 2 || st.CacheSaved != Units(0.01) {
+		t.Errorf("snapshot = current %d, hits %d, saved %s; want 1, 2, 0.010000", st.Current, st.CacheHits, st.CacheSaved)
+	}
+}
+
+func TestTransportCacheRespectsNoStore(t *testing.T) {
+	srv, hits := newCachedTestServer(t, "no-store")
+
+	l := New()
+	l.Register(Resource{
+		Name:    "uncached",
+		Pattern: "*",
+		Limit:   10,
+		Window:  PerMinute,
+		Cache:   &CachePolicy{DefaultTTL: time.Minute},
+	})
+	client := &http.Client{Transport: l.Transport(nil)}
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if *hits != 2 {
+		t.Errorf("upstream hits = %d, want 2", *hits)
+	}
+}
+
+func TestTransportCachePerCredentials(t *testing.T) {
+	srv, hits := newCachedTestServer(t, "max-age=60")
+
+	l := New()
+	l.Register(Resource{
+		Name:    "geo",
+		Pattern: "*",
+		Limit:   10,
+		Window:  PerMinute,
+		Cache:   &CachePolicy{MaxEntries: 10},
+	})
+	client := &http.Client{Transport: l.Transport(nil)}
+
+	for _, token := range []string{"Bearer a", "Bearer a", "Bearer b"} {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/geocode", nil)
+		req.Header.Set("Authorization", token)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if *hits != 2 {
+		t.Errorf("upstream hits = %d, want 2: one per credential", *hits)
+	}
+}
+
+func TestResponseCacheVaryAndInvalidation(t *testing.T) {
+	c := newResponseCache(CachePolicy{})
+	now := time.Now()
+
+	get := func(lang string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/a", nil)
+		req.Header.Set("Accept-Language", lang)
+		return req
+	}
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Cache-Control": {"max-age=60"},
+			"Vary":          {"Accept-Language"},
+		},
+		Body: io.NopCloser(http.NoBody),
+	}
+	if err := c.store("k", get("en"), resp, now, now); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.get("k", get("en"), now) == nil {
+		t.Error("same Vary value: expected hit")
+	}
+	if c.get("k", get("de"), now) != nil {
+		t.Error("different Vary value: expected miss")
+	}
+	if c.get("k", get("en"), now.Add(time.Minute)) != nil {
+		t.Error("stale entry: expected miss")
+	}
+
+	post := httptest.NewRequest(http.MethodPost, "http://example.com/a", nil)
+	c.store("k", post, &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}, now, now)
+	if c.get("k", get("en"), now) != nil {
+		t.Error("after POST: expected entry to be invalidated")
+	}
+}
+
+func TestResponseCacheLifetime(t *testing.T) {
+	date := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	c := newResponseCache(CachePolicy{DefaultTTL: 5 * time.Second})
+
+	tests := []struct {
+		name   string
+		status int
+		header http.Header
+		want   time.Duration
+		ok     bool
+	}{
+		{"max-age", 200, http.Header{"Cache-Control": {"max-age=30"}}, 30 * time.Second, true},
+		{"expires", 200, http.Header{"Expires": {date.Add(time.Hour).Format(http.TimeFormat)}}, time.Hour, true},
+		{"invalid expires", 200, http.Header{"Expires": {"0"}}, 0, false},
+		{"last-modified", 200, http.Header{"Last-Modified": {date.Add(-10 * time.Hour).Format(http.TimeFormat)}}, time.Hour, true},
+		{"default ttl", 200, http.Header{}, 5 * time.Second, true},
+		{"not heuristically cacheable", 500, http.Header{}, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Header: tt.header}
+			got, ok := c.lifetime(resp, parseCacheControl(tt.header), date)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("lifetime = %v, %v; want %v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestResponseCacheEviction(t *testing.T) {
+	c := newResponseCache(CachePolicy{MaxEntries: 1})
+	now := time.Now()
+
+	for _, path := range []string{"/a", "/b"} {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com"+path, nil)
+		resp := &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Cache-Control": {"max-age=60"}},
+			Body:       io.NopCloser(http.NoBody),
+		}
+		c.store(path, req, resp, now, now)
+	}
+
+	if c.get("/a", httptest.NewRequest(http.MethodGet, "http://example.com/a", nil), now) != nil {
+		t.Error("evicted entry still served")
+	}
+	if c.get("/b", httptest.NewRequest(http.MethodGet, "http://example.com/b", nil), now) == nil {
+		t.Error("newest entry missing")
+	}
+}
@@ -0,0 +1,51 @@
+// Command erl works with the counters erl keeps in a shared store.
+//
+// Usage:
+//
+//	erl report [flags]
+//
+// The report subcommand writes usage per resource, partition and period as
+// CSV or JSON; run "erl report -h" for its flags.
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := run(ctx, os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "erl:", err)
+		os.Exit(1)
+	}
+}
+
+const usage = `Usage: erl <command> [flags]
+
+Commands:
+  report    write usage per resource, partition and period as CSV or JSON
+`
+
+// run executes the subcommand named by args[0].
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return fmt.Errorf("no command given")
+	}
+	switch args[0] {
+	case "report":
+		return report(ctx, args[1:], stdout, stderr)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return nil
+	default:
+		fmt.Fprint(stderr, usage)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
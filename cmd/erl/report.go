@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/redis/go-redis/v9"
+	"github.com/ryhazerus/erl"
+	"github.com/ryhazerus/erl/store"
+	erlpostgres "github.com/ryhazerus/erl/store/postgres"
+	erlredis "github.com/ryhazerus/erl/store/redis"
+)
+
+// stringsFlag collects a flag given several times.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+// periods maps -period values to windows.
+var periods = map[string]erl.Window{
+	"minute": erl.PerMinute,
+	"hour":   erl.PerHour,
+	"day":    erl.PerDay,
+	"month":  erl.PerMonth,
+}
+
+// report runs the report subcommand.
+func report(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, `Usage: erl report -store <store> [flags]
+
+Writes the usage archived in an erl store, per resource, partition and
+period. The store must have been written with history enabled.
+
+Flags:
+`)
+		fs.PrintDefaults()
+	}
+
+	var resources stringsFlag
+	dsn := fs.String("store", "", "store to read: a SQLite file path, a postgres:// URL or a redis:// URL")
+	var rf redisFlags
+	fs.StringVar(&rf.prefix, "redis-prefix", "erl:", "key prefix of a Redis store")
+	fs.StringVar(&rf.hashTag, "redis-hash-tag", "", "hash tag of a Redis store's keys (default: erl with -redis-cluster, none otherwise)")
+	fs.BoolVar(&rf.cluster, "redis-cluster", false, "connect to the Redis store as a Redis Cluster")
+	from := fs.String("from", "", "first day to report, as 2006-01-02 or 2006-01 (default: start of last month)")
+	to := fs.String("to", "", "day after the last to report, as 2006-01-02 or 2006-01 (default: start of this month)")
+	period := fs.String("period", "month", "period to total usage by: minute, hour, day or month")
+	byPartition := fs.Bool("by-partition", false, "report each partition, e.g. each tenant, on its own row")
+	format := fs.String("format", "csv", "output format: csv or json")
+	out := fs.String("o", "", "file to write the report to (default: standard output)")
+	fs.Var(&resources, "resource", "resource to report; repeat for several (default: every resource in the store)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		rf.hashTagSet = rf.hashTagSet || f.Name == "redis-hash-tag"
+	})
+
+	if *dsn == "" {
+		fs.Usage()
+		return fmt.Errorf("report: -store is required")
+	}
+	p, ok := periods[*period]
+	if !ok {
+		return fmt.Errorf("report: unknown period %q", *period)
+	}
+	write := erl.WriteReportCSV
+	switch *format {
+	case "csv":
+	case "json":
+		write = erl.WriteReportJSON
+	default:
+		return fmt.Errorf("report: unknown format %q", *format)
+	}
+
+	thisMonth := erl.PerMonth.BucketStart(time.Now())
+	opts := erl.ReportOptions{
+		From:        thisMonth.AddDate(0, -1, 0),
+		To:          thisMonth,
+		Period:      p,
+		Resources:   resources,
+		ByPartition: *byPartition,
+	}
+	if *from != "" {
+		t, err := parseDate(*from)
+		if err != nil {
+			return fmt.Errorf("report: -from: %w", err)
+		}
+		opts.From = t
+	}
+	if *to != "" {
+		t, err := parseDate(*to)
+		if err != nil {
+			return fmt.Errorf("report: -to: %w", err)
+		}
+		opts.To = t
+	}
+
+	s, err := openStore(ctx, *dsn, rf)
+	if err != nil {
+		return fmt.Errorf("report: %w", err)
+	}
+	defer s.Close()
+
+	rows, err := erl.Report(ctx, s, opts)
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		return write(stdout, rows)
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return fmt.Errorf("report: %w", err)
+	}
+	if err := write(f, rows); err != nil {
+		f.Close()
+		return fmt.Errorf("report: %w", err)
+	}
+	return f.Close()
+}
+
+// redisFlags describes how a Redis store's keys were written.
+type redisFlags struct {
+	prefix     string
+	hashTag    string
+	hashTagSet bool
+	cluster    bool
+}
+
+// openStore opens the store named by dsn for reading. It never changes the
+// store: SQLite files are opened read-only and must exist, and the Postgres
+// schema must already be up to date.
+func openStore(ctx context.Context, dsn string, rf redisFlags) (store.Store, error) {
+	switch {
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"):
+		db, err := sql.Open("pgx", dsn)
+		if err != nil {
+			return nil, err
+		}
+		s, err := erlpostgres.NewPostgresStore(ctx, db, erlpostgres.WithoutMigrations())
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		return s, nil
+	case strings.HasPrefix(dsn, "redis://"), strings.HasPrefix(dsn, "rediss://"):
+		var client redis.UniversalClient
+		if rf.cluster {
+			o, err := redis.ParseClusterURL(dsn)
+			if err != nil {
+				return nil, err
+			}
+			client = redis.NewClusterClient(o)
+		} else {
+			o, err := redis.ParseURL(dsn)
+			if err != nil {
+				return nil, err
+			}
+			client = redis.NewClient(o)
+		}
+		opts := []erlredis.Option{erlredis.WithKeyPrefix(rf.prefix)}
+		if rf.hashTagSet {
+			opts = append(opts, erlredis.WithHashTag(rf.hashTag))
+		}
+		return erlredis.NewRedisStore(client, opts...), nil
+	default:
+		path := strings.TrimPrefix(dsn, "sqlite:")
+		if _, err := os.Stat(path); err != nil {
+			return nil, err
+		}
+		return store.NewSQLiteStore("file:" + path + "?mode=ro")
+	}
+}
+
+// parseDate parses a UTC day as 2006-01-02 or the first of a month as
+// 2006-01.
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", "2006-01"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q, want 2006-01-02 or 2006-01", s)
+}
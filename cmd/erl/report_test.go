@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ryhazerus/erl"
+	"github.com/ryhazerus/erl/store"
+)
+
+// newStoreFile writes a SQLite store with January and February 2024 usage of
+// two tenants of openai and returns its path.
+func newStoreFile(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "erl.db")
+	s, err := store.NewSQLiteStore(path, store.WithHistory(100*365*24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	for _, inc := range []struct {
+		key   string
+		day   time.Time
+		delta int64
+	}{
+		{"openai:acme", time.Date(2024, 1, 30, 0, 0, 0, 0, time.UTC), 3},
+		{"openai:acme#spend", time.Date(2024, 1, 30, 0, 0, 0, 0, time.UTC), int64(erl.Units(0.03))},
+		{"openai:acme", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), 4},
+		{"openai:globex", time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC), 1},
+	} {
+		w := store.Window{Duration: 24 * time.Hour, BucketKey: inc.day.Format("2006-01-02"), BucketStart: inc.day}
+		if _, err := s.IncrementMany(ctx, []store.Increment{{Key: inc.key, Window: w, Delta: inc.delta}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func TestReportCSV(t *testing.T) {
+	path := newStoreFile(t)
+
+	var stdout, stderr bytes.Buffer
+	err := run(context.Background(), []string{
+		"report", "-store", path, "-from", "2024-01", "-to", "2024-03", "-by-partition",
+	}, &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, stderr.String())
+	}
+	want := "resource,partition,period,start,requests,spend,currency\n" +
+		"openai,acme,2024-01,2024-01-01T00:00:00Z,3,0.030000,\n" +
+		"openai,acme,2024-02,2024-02-01T00:00:00Z,4,0.000000,\n" +
+		"openai,globex,2024-01,2024-01-01T00:00:00Z,1,0.000000,\n"
+	if stdout.String() != want {
+		t.Errorf("report =\n%s\nwant\n%s", stdout.String(), want)
+	}
+}
+
+func TestReportJSONToFile(t *testing.T) {
+	path := newStoreFile(t)
+	out := filepath.Join(t.TempDir(), "report.json")
+
+	var stdout, stderr bytes.Buffer
+	err := run(context.Background(), []string{
+		"report", "-store", "sqlite:" + path, "-resource", "openai",
+		"-from", "2024-01-01", "-to", "2024-02-01", "-period", "day", "-format", "json", "-o", out,
+	}, &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, stderr.String())
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("stdout = %q, want nothing", stdout.String())
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows []struct {
+		Resource string `json:"resource"`
+		Period   string `json:"period"`
+		Requests int64  `json:"requests"`
+	}
+	if err := json.Unmarshal(data, &rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].Period != "2024-01-30" || rows[0].Requests != 3 || rows[1].Period != "2024-01-31" {
+		t.Errorf("rows = %+v", rows)
+	}
+}
+
+func TestReportLeavesStoreUnchanged(t *testing.T) {
+	path := newStoreFile(t)
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := run(context.Background(), []string{"report", "-store", path, "-from", "2024-01"}, &stdout, &stderr); err != nil {
+		t.Fatalf("%v\n%s", err, stderr.String())
+	}
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Error("report changed the store file")
+	}
+
+	missing := filepath.Join(t.TempDir(), "typo.db")
+	if err := run(context.Background(), []string{"report", "-store", missing}, &stdout, &stderr); err == nil {
+		t.Error("expected an error for a missing store file")
+	}
+	if _, err := os.Stat(missing); err == nil {
+		t.Error("report created the missing store file")
+	}
+}
+
+func TestReportErrors(t *testing.T) {
+	path := newStoreFile(t)
+	for _, args := range [][]string{
+		{},
+		{"bogus"},
+		{"report"},
+		{"report", "-store", path, "-period", "fortnight"},
+		{"report", "-store", path, "-format", "xml"},
+		{"report", "-store", path, "-from", "January"},
+		{"report", "-store", path + ".missing"},
+	} {
+		var stdout, stderr bytes.Buffer
+		if err := run(context.Background(), args, &stdout, &stderr); err == nil {
+			t.Errorf("%s: expected an error", strings.Join(args, " "))
+		}
+	}
+}
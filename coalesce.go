@@ -0,0 +1,135 @@
+package erl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// flight is an upstream call shared by identical concurrent requests.
+type flight struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int            // callers still waiting; guarded by flightGroup.mu
+	resp    *http.Response // body already read into body
+	body    []byte
+	err     error
+}
+
+// flightGroup de-duplicates identical in-flight requests for one resource so
+// only the first is sent upstream and charged.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{flights: make(map[string]*flight)}
+}
+
+// coalescable reports whether req may share a response with identical
+// concurrent requests: only bodiless GET and HEAD requests qualify.
+func coalescable(req *http.Request) bool {
+	return (req.Method == http.MethodGet || req.Method == http.MethodHead) &&
+		(req.Body == nil || req.Body == http.NoBody)
+}
+
+// flightKey identifies identical requests. All headers are part of the key so
+// callers with different credentials never share a response.
+func flightKey(partition string, req *http.Request) string {
+	var b strings.Builder
+	b.WriteString(partition)
+	b.WriteByte('\n')
+	b.WriteString(req.Method)
+	b.WriteByte(' ')
+	b.WriteString(req.URL.String())
+
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.WriteByte('\n')
+		b.WriteString(name)
+		b.WriteByte(':')
+		b.WriteString(strings.Join(req.Header[name], ","))
+	}
+	return b.String()
+}
+
+// do calls fn for the first request with a given key and hands every caller
+// that arrives while it is in flight its own copy of the response. fn runs in
+// its own goroutine with a copy of req whose context carries req's values but
+// not its cancellation, so the first caller giving up does not fail the
+// others. A caller whose context ends while waiting gets the context's
+// error; once every caller has given up, fn's context is canceled.
+func (g *flightGroup) do(key string, req *http.Request, fn func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	g.mu.Lock()
+	f, ok := g.flights[key]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.WithoutCancel(req.Context()))
+		f = &flight{done: make(chan struct{}), cancel: cancel}
+		g.flights[key] = f
+		go g.run(key, f, req.WithContext(ctx), fn)
+	}
+	f.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.copy(req)
+	case <-req.Context().Done():
+		g.mu.Lock()
+		f.waiters--
+		if f.waiters == 0 && g.flights[key] == f {
+			delete(g.flights, key)
+			f.cancel()
+		}
+		g.mu.Unlock()
+		return nil, req.Context().Err()
+	}
+}
+
+// run makes the shared call of f and releases its waiters, even if fn
+// panics.
+func (g *flightGroup) run(key string, f *flight, req *http.Request, fn func(*http.Request) (*http.Response, error)) {
+	defer func() {
+		if p := recover(); p != nil {
+			f.resp, f.body = nil, nil
+			f.err = fmt.Errorf("erl: coalesced request panicked: %v", p)
+		}
+		g.mu.Lock()
+		if g.flights[key] == f {
+			delete(g.flights, key)
+		}
+		g.mu.Unlock()
+		f.cancel()
+		close(f.done)
+	}()
+
+	resp, err := fn(req)
+	if err == nil {
+		f.body, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	f.resp, f.err = resp, err
+}
+
+// copy returns a response equivalent to the flight's with a fresh body.
+func (f *flight) copy(req *http.Request) (*http.Response, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	resp := *f.resp
+	resp.Header = f.resp.Header.Clone()
+	resp.Body = io.NopCloser(bytes.NewReader(f.body))
+	resp.ContentLength = int64(len(f.body))
+	resp.Request = req
+	return &resp, nil
+}
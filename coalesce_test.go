@@ -0,0 +1,152 @@
+package erl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTransportCoalesce(t *testing.T) {
+	var hits atomic.Int64
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		<-release
+		w.Write([]byte("shared"))
+	}))
+	defer srv.Close()
+
+	l := New()
+	l.Register(Resource{
+		Name:     "rates",
+		Pattern:  "*",
+		Limit:    100,
+		Window:   PerMinute,
+		Strategy: Block,
+		Coalesce: true,
+	})
+	client := &http.Client{Transport: l.Transport(nil)}
+
+	const callers = 50
+	var wg sync.WaitGroup
+	bodies := make(chan string, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(srv.URL + "/rates")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			bodies <- string(body)
+		}()
+	}
+
+	// Give every caller time to join the in-flight request.
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(bodies)
+
+	for body := range bodies {
+		if body != "shared" {
+			t.Errorf("body = %q, want %q", body, "shared")
+		}
+	}
+	if got := hits.Load(); got != 1 {
+		t.Errorf("upstream hits = %d, want 1", got)
+	}
+	if usage, _ := l.GetUsage(context.Background(), "rates"); usage != 1 {
+		t.Errorf("usage = %d, want 1", usage)
+	}
+}
+
+func TestFlightKeyHeaders(t *testing.T) {
+	a := httptest.NewRequest(http.MethodGet, "http://example.com/a", nil)
+	a.Header.Set("Authorization", "Bearer one")
+	b := httptest.NewRequest(http.MethodGet, "http://example.com/a", nil)
+	b.Header.Set("Authorization", "Bearer two")
+
+	if flightKey("", a) == flightKey("", b) {
+		t.Error("requests with different credentials share a flight key")
+	}
+	if coalescable(httptest.NewRequest(http.MethodPost, "http://example.com/a", nil)) {
+		t.Error("POST requests must not be coalesced")
+	}
+}
+
+func TestFlightGroupLeaderCanceled(t *testing.T) {
+	g := newFlightGroup()
+	release := make(chan struct{})
+	fn := func(req *http.Request) (*http.Response, error) {
+		<-release
+		if err := req.Context().Err(); err != nil {
+			return nil, err
+		}
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("shared"))}, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := httptest.NewRequest(http.MethodGet, "http://example.com/a", nil).WithContext(ctx)
+	follower := httptest.NewRequest(http.MethodGet, "http://example.com/a", nil)
+
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, err := g.do("a", leader, fn)
+		leaderErr <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	result := make(chan error, 1)
+	go func() {
+		resp, err := g.do("a", follower, fn)
+		if err == nil {
+			body, _ := io.ReadAll(resp.Body)
+			if string(body) != "shared" {
+				err = fmt.Errorf("body = %q", body)
+			}
+		}
+		result <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	cancel()
+	if err := <-leaderErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("leader err = %v, want context.Canceled", err)
+	}
+	close(release)
+	if err := <-result; err != nil {
+		t.Errorf("follower: %v", err)
+	}
+}
+
+func TestFlightGroupPanic(t *testing.T) {
+	g := newFlightGroup()
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/a", nil)
+
+	_, err := g.do("a", req, func(*http.Request) (*http.Response, error) {
+		panic("boom")
+	})
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("err = %v, want the panic as an error", err)
+	}
+
+	// The flight is gone, so the next call runs fn again.
+	resp, err := g.do("a", req, func(*http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: http.NoBody}, nil
+	})
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("after panic: %v, %v", resp, err)
+	}
+}
@@ -0,0 +1,93 @@
+package erl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Allow charges n calls to the named resource and its quota groups and
+// enforces their strategies, exactly as Check does for a matching URL. Use
+// it for calls that do not go over HTTP, such as SMTP or vendor SDKs. The
+// resource's PartitionFunc is called with a nil request.
+//
+// n must be positive and no larger than the Limit of any counter that would
+// refuse the calls; otherwise Allow returns an error without charging
+// anything.
+func (l *Limiter) Allow(ctx context.Context, name string, n int64) error {
+	if n <= 0 {
+		return fmt.Errorf("erl: cannot charge %d calls to %q", n, name)
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	r, ok := l.lookup(name)
+	if !ok {
+		return fmt.Errorf("erl: resource %q not found", name)
+	}
+	charges, err := l.chain(ctx, r, nil)
+	if err != nil {
+		return err
+	}
+	for _, c := range charges {
+		if c.resource.Strategy != LogOnly && c.resource.countLimited() && n > c.resource.Limit {
+			return fmt.Errorf("erl: %d calls exceed the limit of %d for %s", n, c.resource.Limit, c.resource.Name)
+		}
+	}
+	_, err = l.applyCharges(ctx, r, charges, n)
+	return err
+}
+
+// Wait is like Allow but, when the limit is exceeded, waits for the window to
+// reset and tries again until the calls are allowed or ctx is done. As with
+// Check, refused attempts are counted. Calls Allow rejects up front are
+// never retried.
+func (l *Limiter) Wait(ctx context.Context, name string, n int64) error {
+	for {
+		err := l.Allow(ctx, name, n)
+		var limErr *LimitExceededError
+		if !errors.As(err, &limErr) {
+			return err
+		}
+		if err := limErr.Wait(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// Do charges one call to the named resource with Allow and, if allowed, runs
+// fn. When the resource has a circuit breaker, an error from fn counts as a
+// failure and an open breaker refuses the call with ErrCircuitOpen.
+func (l *Limiter) Do(ctx context.Context, name string, fn func(context.Context) error) error {
+	l.mu.RLock()
+	r, ok := l.lookup(name)
+	st := l.states[name]
+	l.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("erl: resource %q not found", name)
+	}
+
+	var b *breaker
+	var probe bool
+	if st != nil && st.breaker != nil {
+		b = st.breaker
+		var err error
+		if probe, err = l.breakerAllow(ctx, r, b); err != nil {
+			return err
+		}
+	}
+
+	if err := l.Allow(ctx, name, 1); err != nil {
+		if b != nil {
+			l.breakerRelease(b, probe)
+		}
+		return err
+	}
+
+	err := fn(ctx)
+	if b != nil {
+		l.breakerRecord(ctx, r, b, probe, err != nil)
+	}
+	return err
+}
@@ -0,0 +1,117 @@
+package erl
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimiterAllow(t *testing.T) {
+	l := New()
+	l.Register(Resource{
+		Name:     "smtp",
+		Limit:    5,
+		Window:   PerMinute,
+		Strategy: Block,
+	})
+
+	ctx := context.Background()
+	if err := l.Allow(ctx, "smtp", 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Allow(ctx, "smtp", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Allow(ctx, "smtp", 1); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("expected ErrLimitExceeded, got %v", err)
+	}
+	if usage, _ := l.GetUsage(ctx, "smtp"); usage != 6 {
+		t.Errorf("usage = %d, want 6", usage)
+	}
+
+	if err := l.Allow(ctx, "missing", 1); err == nil || errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
+
+func TestLimiterAllowRejectsBadCounts(t *testing.T) {
+	l := New()
+	l.Register(Resource{Name: "smtp", Limit: 5, Window: PerMinute, Strategy: Block})
+	l.Register(Resource{Name: "audit", Limit: 5, Window: PerMinute, Strategy: LogOnly})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, n := range []int64{0, -3, 6} {
+		if err := l.Allow(ctx, "smtp", n); err == nil || errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("Allow(%d): err = %v, want a usage error", n, err)
+		}
+		if err := l.Wait(ctx, "smtp", n); err == nil || errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Wait(%d): err = %v, want a usage error", n, err)
+		}
+	}
+	if usage, _ := l.GetUsage(ctx, "smtp"); usage != 0 {
+		t.Errorf("usage = %d, want nothing charged", usage)
+	}
+
+	// A LogOnly resource never refuses calls, so any positive count goes.
+	if err := l.Allow(ctx, "audit", 6); err != nil {
+		t.Errorf("log only: %v", err)
+	}
+}
+
+func TestLimiterWait(t *testing.T) {
+	l := New()
+	l.Register(Resource{
+		Name:     "sdk",
+		Limit:    1,
+		Window:   PerMonth,
+		Strategy: BlockWithQueue,
+	})
+
+	ctx := context.Background()
+	if err := l.Wait(ctx, "sdk", 1); err != nil {
+		t.Fatalf("first wait: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx, "sdk", 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestLimiterDo(t *testing.T) {
+	l := New()
+	l.Register(Resource{
+		Name:     "grpc-vendor",
+		Limit:    10,
+		Window:   PerMinute,
+		Strategy: Block,
+		Breaker:  &BreakerPolicy{MinRequests: 2, FailureRatio: 0.5, OpenFor: time.Minute},
+	})
+
+	ctx := context.Background()
+	var calls int
+	boom := errors.New("boom")
+	for i := 0; i < 2; i++ {
+		err := l.Do(ctx, "grpc-vendor", func(context.Context) error {
+			calls++
+			return boom
+		})
+		if !errors.Is(err, boom) {
+			t.Fatalf("call %d: expected fn error, got %v", i+1, err)
+		}
+	}
+
+	err := l.Do(ctx, "grpc-vendor", func(context.Context) error {
+		calls++
+		return nil
+	})
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("calls = %d, want 2", calls)
+	}
+}
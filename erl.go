@@ -5,7 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ryhazerus/erl/store"
@@ -17,19 +19,40 @@ var ErrLimitExceeded = errors.New("erl: rate limit exceeded")
 // LimitExceededError provides details about which resource hit its limit
 // and supports waiting for the window to reset (BlockWithQueue strategy).
 type LimitExceededError struct {
-	Resource Resource
-	Current  int64
-	resetAt  time.Time
+	Resource  Resource
+	Partition string // partition that hit its limit, empty for the shared counter
+	Current   int64
+	Spent     Money // spend in the window, for resources with a Budget
+	resetAt   time.Time
+	budget    bool
 }
 
 func (e *LimitExceededError) Error() string {
-	return fmt.Sprintf("erl: rate limit exceeded for %s (%d/%d)", e.Resource.Name, e.Current, e.Resource.Limit)
+	name := e.Resource.Name
+	if e.Partition != "" {
+		name += "[" + e.Partition + "]"
+	}
+	if e.budget {
+		return fmt.Sprintf("erl: budget exceeded for %s (%s/%s %s)", name, e.Spent, e.Resource.Budget, e.Resource.Currency)
+	}
+	return fmt.Sprintf("erl: rate limit exceeded for %s (%d/%d)", name, e.Current, e.Resource.Limit)
+}
+
+// Remaining returns the budget left in the current window, or zero if the
+// resource has no Budget or it is used up.
+func (e *LimitExceededError) Remaining() Money {
+	return remaining(e.Resource.Budget, e.Spent)
 }
 
 func (e *LimitExceededError) Unwrap() error {
 	return ErrLimitExceeded
 }
 
+// ResetAt returns when the window that hit its limit resets.
+func (e *LimitExceededError) ResetAt() time.Time {
+	return e.resetAt
+}
+
 // Wait blocks until the current window resets or the context is cancelled.
 // This is intended for use with the BlockWithQueue strategy.
 func (e *LimitExceededError) Wait(ctx context.Context) error {
@@ -50,16 +73,27 @@ func (e *LimitExceededError) Wait(ctx context.Context) error {
 // Limiter is the main entry point for the erl library. It tracks outgoing HTTP
 // requests against registered resources and enforces configurable rate limits.
 type Limiter struct {
-	mu             sync.RWMutex
-	resources      []Resource
-	store          store.Store
-	onLimitReached func(Resource, int64)
+	mu              sync.RWMutex
+	resources       []Resource
+	states          map[string]*resourceState
+	store           store.Store
+	matchMode       MatchMode
+	limits          LimitResolver
+	local           *store.MemoryStore // process-local state that is not shared through the store
+	onLimitReached  func(Resource, int64)
+	onThreshold     func(ThresholdEvent)
+	onShadowDenied  func(Resource, int64)
+	onBreakerChange func(r Resource, from, to BreakerState)
+	dryRun          bool
 }
 
 // New creates a new Limiter with the given options.
 // If no store is provided, an in-memory store is used.
 func New(opts ...Option) *Limiter {
-	l := &Limiter{}
+	l := &Limiter{
+		states: make(map[string]*resourceState),
+		local:  store.NewMemoryStore(),
+	}
 	for _, o := range opts {
 		o(l)
 	}
@@ -74,87 +108,453 @@ func (l *Limiter) Register(r Resource) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	l.resources = append(l.resources, r)
+	l.states[r.Name] = newResourceState(r)
+}
+
+// resourceState holds the runtime state the limiter keeps per resource.
+type resourceState struct {
+	cache     *responseCache
+	cacheHits atomic.Int64
+	flights   *flightGroup
+	breaker   *breaker
+}
+
+func newResourceState(r Resource) *resourceState {
+	st := &resourceState{}
+	if r.Cache != nil {
+		st.cache = newResponseCache(*r.Cache)
+	}
+	if r.Coalesce {
+		st.flights = newFlightGroup()
+	}
+	if r.Breaker != nil {
+		st.breaker = newBreaker(*r.Breaker)
+	}
+	return st
+}
+
+// route returns the resource matching req with its runtime state and the
+// request's partition.
+func (l *Limiter) route(req *http.Request) (Resource, *resourceState, string, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	r, ok := l.match(req.URL.String())
+	if !ok {
+		return Resource{}, nil, "", false
+	}
+	var partition string
+	if r.Partition != nil {
+		partition = r.Partition(req.Context(), req)
+	}
+	return r, l.states[r.Name], partition, true
 }
 
 // Check tests whether a request to the given URL is allowed.
 // It increments the counter and enforces the resource's strategy.
 // Returns nil if the request is allowed, or an error if it should be blocked.
 func (l *Limiter) Check(ctx context.Context, rawURL string) error {
+	_, err := l.check(ctx, rawURL, nil)
+	return err
+}
+
+// CheckRequest is like Check but also makes the request's headers available
+// to the resource's PartitionFunc.
+func (l *Limiter) CheckRequest(req *http.Request) error {
+	_, err := l.check(req.Context(), req.URL.String(), req)
+	return err
+}
+
+// check matches rawURL and applies the resource's limits. It returns the
+// charges made so the caller can settle response-based prices.
+func (l *Limiter) check(ctx context.Context, rawURL string, req *http.Request) ([]charge, error) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 
-	for _, r := range l.resources {
-		if !matchURL(rawURL, r.Pattern) {
-			continue
-		}
+	r, ok := l.match(rawURL)
+	if !ok {
+		// No matching resource; allow.
+		return nil, nil
+	}
+	return l.apply(ctx, r, req, 1)
+}
+
+// charge is one counter updated by a check: the matched resource or one of
+// the quota groups it draws from.
+type charge struct {
+	resource  Resource // Limit resolved for the partition
+	partition string
+	window    store.Window
+	current   int64
+	spent     Money // spend including this request, when resource.Budget > 0
+
+	// Shadow evaluation; for dry runs without a Shadow these mirror the
+	// live window and counter.
+	shadowWindow  store.Window
+	shadowCurrent int64
+}
 
-		now := time.Now()
-		w := store.Window{
-			Duration:    r.Window.Duration(),
-			BucketKey:   r.Window.BucketKey(now),
-			BucketStart: r.Window.BucketStart(now),
+func (c *charge) key() string {
+	return counterKey(c.resource.Name, c.partition)
+}
+
+func (c *charge) exceeded(budget bool) *LimitExceededError {
+	return &LimitExceededError{
+		Resource:  c.resource,
+		Partition: c.partition,
+		Current:   c.current,
+		Spent:     c.spent,
+		resetAt:   c.window.BucketStart.Add(c.window.Duration),
+		budget:    budget,
+	}
+}
+
+// apply charges n calls to the counters of r and every quota group above it
+// in one store operation, then enforces the strategy of each counter that is
+// over its limit or budget. The innermost blocking counter determines the
+// returned error. The caller must hold l.mu.
+func (l *Limiter) apply(ctx context.Context, r Resource, req *http.Request, n int64) ([]charge, error) {
+	charges, err := l.chain(ctx, r, req)
+	if err != nil {
+		return nil, err
+	}
+	return l.applyCharges(ctx, r, charges, n)
+}
+
+// applyCharges is apply for charges already resolved with chain.
+func (l *Limiter) applyCharges(ctx context.Context, r Resource, charges []charge, n int64) ([]charge, error) {
+	now := time.Now()
+	price := r.Price * Money(n)
+	incs := make([]store.Increment, 0, len(charges))
+	for i := range charges {
+		c := &charges[i]
+		c.window = c.resource.Window.storeWindow(now)
+		incs = append(incs, store.Increment{Key: c.key(), Window: c.window, Delta: n})
+		if c.resource.Budget > 0 {
+			incs = append(incs, store.Increment{Key: spendKey(c.key()), Window: c.window, Delta: int64(price)})
+		}
+		if sh := c.resource.Shadow; sh != nil {
+			c.shadowWindow = sh.Window.storeWindow(now)
+			incs = append(incs, store.Increment{Key: shadowKey(c.key()), Window: c.shadowWindow, Delta: n})
 		}
+	}
 
-		current, err := l.store.Increment(ctx, r.Name, w)
-		if err != nil {
-			return fmt.Errorf("erl: store error: %w", err)
+	counts, err := l.incrementAll(ctx, incs)
+	if err != nil {
+		return nil, fmt.Errorf("erl: store error: %w", err)
+	}
+
+	var blocked error
+	var denied []*charge
+	for i := range charges {
+		c := &charges[i]
+		c.current, counts = counts[0], counts[1:]
+		if c.resource.Budget > 0 {
+			c.spent, counts = Money(counts[0]), counts[1:]
+		}
+		if c.resource.Shadow != nil {
+			c.shadowCurrent, counts = counts[0], counts[1:]
 		}
+		l.warn(ctx, c, n, price)
+
+		overLimit := c.resource.countLimited() && c.current > c.resource.Limit
+		// A request with no up-front price is only refused once the budget
+		// is fully used, since its cost is not known yet.
+		overBudget := c.resource.Budget > 0 &&
+			(c.spent > c.resource.Budget || price == 0 && c.spent >= c.resource.Budget)
 
-		if current > r.Limit {
-			if l.onLimitReached != nil {
-				l.onLimitReached(r, current)
+		if sh := c.resource.Shadow; sh != nil {
+			if c.shadowCurrent > sh.Limit {
+				denied = append(denied, c)
 			}
+		} else if l.dryRun && (overLimit || overBudget) && c.resource.Strategy != LogOnly {
+			c.shadowWindow, c.shadowCurrent = c.window, c.current
+			denied = append(denied, c)
+		}
 
-			switch r.Strategy {
-			case Block:
-				return &LimitExceededError{
-					Resource: r,
-					Current:  current,
-					resetAt:  w.BucketStart.Add(w.Duration),
-				}
-			case BlockWithQueue:
-				return &LimitExceededError{
-					Resource: r,
-					Current:  current,
-					resetAt:  w.BucketStart.Add(w.Duration),
-				}
-			case LogOnly:
-				// Allow the request through.
-				return nil
+		if !overLimit && !overBudget {
+			continue
+		}
+
+		if l.onLimitReached != nil {
+			l.onLimitReached(c.resource, c.current)
+		}
+
+		switch c.resource.Strategy {
+		case Block, BlockWithQueue, Fallback:
+			if blocked == nil && !l.dryRun {
+				blocked = c.exceeded(!overLimit)
 			}
+		case LogOnly:
+			// Allow the request through.
 		}
+	}
+
+	if err := l.recordShadowDenials(ctx, denied); err != nil {
+		return nil, err
+	}
+
+	return charges, blocked
+}
 
-		// Matched a resource and under the limit; allow.
+// settle charges the price computed by the matched resource's PriceFunc to
+// the budgets of the charges made for the request.
+func (l *Limiter) settle(ctx context.Context, charges []charge, req *http.Request, resp *http.Response) error {
+	if len(charges) == 0 || charges[0].resource.PriceFunc == nil {
+		return nil
+	}
+	price := charges[0].resource.PriceFunc(req, resp)
+	if price == 0 {
 		return nil
 	}
 
-	// No matching resource; allow.
+	var incs []store.Increment
+	var budgeted []*charge
+	for i := range charges {
+		c := &charges[i]
+		if c.resource.Budget > 0 {
+			incs = append(incs, store.Increment{Key: spendKey(c.key()), Window: c.window, Delta: int64(price)})
+			budgeted = append(budgeted, c)
+		}
+	}
+	if len(incs) == 0 {
+		return nil
+	}
+
+	spent, err := l.incrementAll(ctx, incs)
+	if err != nil {
+		return fmt.Errorf("erl: store error: %w", err)
+	}
+	for i, c := range budgeted {
+		c.spent = Money(spent[i])
+		l.warn(ctx, c, 0, price)
+	}
 	return nil
 }
 
-// GetUsage returns the current counter for a resource in the active window.
-func (l *Limiter) GetUsage(ctx context.Context, name string) (int64, error) {
-	l.mu.RLock()
-	defer l.mu.RUnlock()
+// chain returns the charges for r followed by those of the quota groups it
+// draws from, with partitions and limits resolved. The caller must hold l.mu.
+func (l *Limiter) chain(ctx context.Context, r Resource, req *http.Request) ([]charge, error) {
+	var out []charge
+	for {
+		var partition string
+		if r.Partition != nil {
+			partition = r.Partition(ctx, req)
+		}
+		if l.limits != nil {
+			limit, ok, err := l.limits.ResolveLimit(ctx, r, partition)
+			if err != nil {
+				return nil, fmt.Errorf("erl: resolve limit: %w", err)
+			}
+			if ok {
+				r.Limit = limit
+			}
+		}
+		out = append(out, charge{resource: r, partition: partition})
+
+		if r.Group == "" {
+			return out, nil
+		}
+		if len(out) > len(l.resources) {
+			return nil, fmt.Errorf("erl: resource %q: group cycle", out[0].resource.Name)
+		}
+
+		parent, ok := l.lookup(r.Group)
+		if !ok {
+			return nil, fmt.Errorf("erl: resource %q: group %q not found", r.Name, r.Group)
+		}
+		r = parent
+	}
+}
 
+// incrementAll applies incs to the limiter's store.
+func (l *Limiter) incrementAll(ctx context.Context, incs []store.Increment) ([]int64, error) {
+	return l.incrementAllIn(ctx, l.store, incs)
+}
+
+// incrementAllIn applies incs atomically when s supports store.Batcher and
+// one at a time otherwise, using store.Adder for weighted increments.
+func (l *Limiter) incrementAllIn(ctx context.Context, s store.Store, incs []store.Increment) ([]int64, error) {
+	if b, ok := store.AsBatcher(s); ok {
+		return b.IncrementMany(ctx, incs)
+	}
+
+	a, adder := s.(store.Adder)
+	out := make([]int64, len(incs))
+	for i, inc := range incs {
+		var current int64
+		var err error
+		switch {
+		case adder:
+			current, err = a.Add(ctx, inc.Key, inc.Window, inc.Delta)
+		case inc.Delta == 0:
+			current, err = s.Get(ctx, inc.Key, inc.Window)
+		case inc.Delta == 1:
+			current, err = s.Increment(ctx, inc.Key, inc.Window)
+		default:
+			return nil, fmt.Errorf("%T does not support weighted increments", s)
+		}
+		if err != nil {
+			return nil, err
+		}
+		out[i] = current
+	}
+	return out, nil
+}
+
+// getAll reads several counters, in one round trip if the store supports it.
+func (l *Limiter) getAll(ctx context.Context, reads []store.Read) ([]int64, error) {
+	if g, ok := l.store.(store.BatchGetter); ok {
+		return g.GetMany(ctx, reads)
+	}
+
+	out := make([]int64, len(reads))
+	for i, rd := range reads {
+		current, err := l.store.Get(ctx, rd.Key, rd.Window)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = current
+	}
+	return out, nil
+}
+
+// lookup returns the registered resource with the given name. The caller
+// must hold l.mu.
+func (l *Limiter) lookup(name string) (Resource, bool) {
 	for _, r := range l.resources {
 		if r.Name == name {
-			now := time.Now()
-			w := store.Window{
-				Duration:    r.Window.Duration(),
-				BucketKey:   r.Window.BucketKey(now),
-				BucketStart: r.Window.BucketStart(now),
+			return r, true
+		}
+	}
+	return Resource{}, false
+}
+
+// Match returns the resource that applies to target, a "host/path" style
+// string matched against patterns without URL parsing, e.g. a gRPC full
+// method name without its leading slash. It applies the same priorities and
+// MatchMode as Check.
+func (l *Limiter) Match(target string) (Resource, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.matchTarget(strings.TrimRight(target, "/"))
+}
+
+// match selects the resource that applies to rawURL according to resource
+// priorities and the limiter's MatchMode. The caller must hold l.mu.
+func (l *Limiter) match(rawURL string) (Resource, bool) {
+	hp, ok := hostPath(rawURL)
+	if !ok {
+		return Resource{}, false
+	}
+	return l.matchTarget(hp)
+}
+
+// matchTarget selects the resource that applies to a normalised host + path.
+// The caller must hold l.mu.
+func (l *Limiter) matchTarget(hp string) (Resource, bool) {
+	best := -1
+	for i, r := range l.resources {
+		if !r.matches(hp) {
+			continue
+		}
+		if best < 0 || l.matchMode.preferred(r, l.resources[best]) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return Resource{}, false
+	}
+	return l.resources[best], true
+}
+
+// exhausted reports whether another call to the resource matching req would
+// be refused: whether it, or a quota group above it, has used its call limit
+// or budget and blocks when it has. LogOnly counters and dry runs never
+// refuse calls.
+func (l *Limiter) exhausted(ctx context.Context, req *http.Request) (bool, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	r, ok := l.match(req.URL.String())
+	if !ok || l.dryRun {
+		return false, nil
+	}
+	charges, err := l.chain(ctx, r, req)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	for i := range charges {
+		c := &charges[i]
+		if c.resource.Strategy == LogOnly {
+			continue
+		}
+		w := c.resource.Window.storeWindow(now)
+		if c.resource.countLimited() {
+			current, err := l.store.Get(ctx, c.key(), w)
+			if err != nil {
+				return false, fmt.Errorf("erl: store error: %w", err)
+			}
+			if current >= c.resource.Limit {
+				return true, nil
+			}
+		}
+		if c.resource.Budget > 0 {
+			spent, err := l.store.Get(ctx, spendKey(c.key()), w)
+			if err != nil {
+				return false, fmt.Errorf("erl: store error: %w", err)
+			}
+			// Mirrors apply: a call without an up-front price is only
+			// refused once the budget is used up.
+			if Money(spent)+r.Price > c.resource.Budget || r.Price == 0 && Money(spent) >= c.resource.Budget {
+				return true, nil
 			}
-			return l.store.Get(ctx, r.Name, w)
 		}
 	}
+	return false, nil
+}
 
-	return 0, fmt.Errorf("erl: resource %q not found", name)
+// GetUsage returns the current counter for a resource in the active window.
+func (l *Limiter) GetUsage(ctx context.Context, name string) (int64, error) {
+	return l.GetPartitionUsage(ctx, name, "")
 }
 
-// ResetUsage resets the counter for a resource.
+// GetPartitionUsage returns the current counter for one partition of a
+// resource in the active window.
+func (l *Limiter) GetPartitionUsage(ctx context.Context, name, partition string) (int64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	r, ok := l.lookup(name)
+	if !ok {
+		return 0, fmt.Errorf("erl: resource %q not found", name)
+	}
+	return l.store.Get(ctx, counterKey(r.Name, partition), r.Window.storeWindow(time.Now()))
+}
+
+// ResetUsage resets the counters for a resource: its call count, spend and
+// shadow counts.
 func (l *Limiter) ResetUsage(ctx context.Context, name string) error {
-	return l.store.Reset(ctx, name)
+	return l.resetCounters(ctx, counterKey(name, ""))
+}
+
+// ResetPartitionUsage resets the counters for one partition of a resource.
+func (l *Limiter) ResetPartitionUsage(ctx context.Context, name, partition string) error {
+	return l.resetCounters(ctx, counterKey(name, partition))
+}
+
+// resetCounters resets the counter with the given key and the counters kept
+// alongside it.
+func (l *Limiter) resetCounters(ctx context.Context, key string) error {
+	var errs []error
+	for _, k := range []string{key, spendKey(key), shadowKey(key), deniedKey(key)} {
+		if err := l.store.Reset(ctx, k); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
 }
 
 // Transport wraps an http.RoundTripper so that all requests made through it
@@ -178,32 +578,110 @@ func (l *Limiter) Resources() []Resource {
 
 // ResourceStatus holds a point-in-time counter for a single resource.
 type ResourceStatus struct {
-	Resource Resource
-	Current  int64
+	Resource  Resource
+	Current   int64
+	Spent     Money // for resources with a Budget
+	Remaining Money // budget left in the window
+
+	// ShadowCurrent is the counter of the resource's Shadow limit, and
+	// ShadowDenied the number of requests in its window that the shadow
+	// limit (or, in a dry run, the live limit) would have blocked.
+	ShadowCurrent int64
+	ShadowDenied  int64
+
+	// CacheHits counts requests served from the resource's response cache
+	// by this limiter, and CacheSaved the Price those requests would have
+	// cost.
+	CacheHits  int64
+	CacheSaved Money
+
+	// Breaker is this instance's circuit breaker state for the resource.
+	Breaker BreakerState
+
+	// Children holds the resources that draw from this one as their quota
+	// group.
+	Children []ResourceStatus
 }
 
 // Snapshot returns the current counter for every registered resource
-// in its active window bucket.
+// in its active window bucket. Partitioned counters are not included; use
+// GetPartitionUsage to read them.
+//
+// Resources that belong to a quota group are reported in the Children of
+// the group's status rather than at the top level.
 func (l *Limiter) Snapshot(ctx context.Context) ([]ResourceStatus, error) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 
-	out := make([]ResourceStatus, 0, len(l.resources))
+	flat := make([]ResourceStatus, len(l.resources))
 	now := time.Now()
 
-	for _, r := range l.resources {
-		w := store.Window{
-			Duration:    r.Window.Duration(),
-			BucketKey:   r.Window.BucketKey(now),
-			BucketStart: r.Window.BucketStart(now),
+	// Every counter is read in one batch; reads[k] lands in dst[k].
+	var reads []store.Read
+	var dst []*int64
+	get := func(key string, w store.Window, p *int64) {
+		reads = append(reads, store.Read{Key: key, Window: w})
+		dst = append(dst, p)
+	}
+
+	spent := make([]int64, len(l.resources))
+	for i, r := range l.resources {
+		flat[i] = ResourceStatus{Resource: r}
+		w := r.Window.storeWindow(now)
+		get(counterKey(r.Name, ""), w, &flat[i].Current)
+		if r.Budget > 0 {
+			get(spendKey(counterKey(r.Name, "")), w, &spent[i])
 		}
+		l.snapshotShadow(&flat[i], now, get)
 
-		current, err := l.store.Get(ctx, r.Name, w)
-		if err != nil {
-			return nil, fmt.Errorf("erl: snapshot %s: %w", r.Name, err)
+		if st := l.states[r.Name]; st != nil {
+			flat[i].CacheHits = st.cacheHits.Load()
+			flat[i].CacheSaved = Money(flat[i].CacheHits) * r.Price
+			if st.breaker != nil {
+				flat[i].Breaker = st.breaker.current()
+			}
+		}
+	}
+
+	counts, err := l.getAll(ctx, reads)
+	if err != nil {
+		return nil, fmt.Errorf("erl: snapshot: %w", err)
+	}
+	for k, p := range dst {
+		*p = counts[k]
+	}
+	for i, r := range l.resources {
+		if r.Budget > 0 {
+			flat[i].Spent = Money(spent[i])
+			flat[i].Remaining = remaining(r.Budget, Money(spent[i]))
+		}
+		if r.Shadow == nil && l.dryRun {
+			flat[i].ShadowCurrent = flat[i].Current
+		}
+	}
+
+	children := make(map[string][]int)
+	var roots []int
+	for i, r := range l.resources {
+		if _, ok := l.lookup(r.Group); r.Group != "" && ok {
+			children[r.Group] = append(children[r.Group], i)
+		} else {
+			roots = append(roots, i)
 		}
+	}
+
+	var build func(i int) ResourceStatus
+	build = func(i int) ResourceStatus {
+		st := flat[i]
+		for _, c := range children[st.Resource.Name] {
+			st.Children = append(st.Children, build(c))
+		}
+		return st
+	}
 
-		out = append(out, ResourceStatus{Resource: r, Current: current})
+	out := make([]ResourceStatus, 0, len(roots))
+	for _, i := range roots {
+		out = append(out, build(i))
 	}
 
 	return out, nil
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"sync"
 	"testing"
+
+	"github.com/ryhazerus/erl/store"
 )
 
 func TestLimiterBlockStrategy(t *testing.T) {
@@ -196,3 +198,369 @@ func TestLimiterResetUsage(t *testing.T) {
 		t.Fatalf("after reset, expected nil error, got: %v", err)
 	}
 }
+
+type tenantKey struct{}
+
+func TestLimiterPartitionKeysDoNotCollide(t *testing.T) {
+	l := New()
+	l.Register(Resource{Name: "a", Pattern: "a.example.com/*", Window: PerMinute, Partition: PartitionFromContext(tenantKey{})})
+	l.Register(Resource{Name: "a:b", Pattern: "ab.example.com/*", Window: PerMinute, Partition: PartitionFromContext(tenantKey{})})
+
+	ctx := context.Background()
+	l.Check(context.WithValue(ctx, tenantKey{}, "b:c"), "https://a.example.com/")
+	l.Check(context.WithValue(ctx, tenantKey{}, "c"), "https://ab.example.com/")
+	l.Check(context.WithValue(ctx, tenantKey{}, "c"), "https://ab.example.com/")
+
+	if got, _ := l.GetPartitionUsage(ctx, "a", "b:c"); got != 1 {
+		t.Errorf("a/b:c usage = %d, want 1", got)
+	}
+	if got, _ := l.GetPartitionUsage(ctx, "a:b", "c"); got != 2 {
+		t.Errorf("a:b/c usage = %d, want 2", got)
+	}
+	if got, _ := l.GetPartitionUsage(ctx, "a", "b"); got != 0 {
+		t.Errorf("a/b usage = %d, want 0", got)
+	}
+}
+
+func TestLimiterPartitionFromContext(t *testing.T) {
+	l := New()
+	l.Register(Resource{
+		Name:      "tenant-api",
+		Pattern:   "api.tenant.com/*",
+		Limit:     2,
+		Window:    PerMinute,
+		Strategy:  Block,
+		Partition: PartitionFromContext(tenantKey{}),
+	})
+
+	url := "https://api.tenant.com/v1/things"
+	acme := context.WithValue(context.Background(), tenantKey{}, "acme")
+	globex := context.WithValue(context.Background(), tenantKey{}, "globex")
+
+	for i := 0; i < 2; i++ {
+		if err := l.Check(acme, url); err != nil {
+			t.Fatalf("acme request %d: %v", i+1, err)
+		}
+	}
+
+	err := l.Check(acme, url)
+	var limErr *LimitExceededError
+	if !errors.As(err, &limErr) {
+		t.Fatalf("expected *LimitExceededError for acme, got %v", err)
+	}
+	if limErr.Partition != "acme" {
+		t.Errorf("partition = %q, want %q", limErr.Partition, "acme")
+	}
+
+	// Another tenant has its own counter.
+	if err := l.Check(globex, url); err != nil {
+		t.Fatalf("globex request: %v", err)
+	}
+
+	ctx := context.Background()
+	if got, _ := l.GetPartitionUsage(ctx, "tenant-api", "acme"); got != 3 {
+		t.Errorf("acme usage = %d, want 3", got)
+	}
+	if got, _ := l.GetPartitionUsage(ctx, "tenant-api", "globex"); got != 1 {
+		t.Errorf("globex usage = %d, want 1", got)
+	}
+	if got, _ := l.GetUsage(ctx, "tenant-api"); got != 0 {
+		t.Errorf("shared usage = %d, want 0", got)
+	}
+
+	if err := l.ResetPartitionUsage(ctx, "tenant-api", "acme"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Check(acme, url); err != nil {
+		t.Fatalf("after reset, expected nil error, got: %v", err)
+	}
+}
+
+func TestLimiterOverrides(t *testing.T) {
+	overrides := NewOverrides()
+	overrides.Set("plan-api", "pro", 3)
+
+	l := New(WithLimitResolver(overrides))
+	l.Register(Resource{
+		Name:      "plan-api",
+		Pattern:   "api.plan.com/*",
+		Limit:     1,
+		Window:    PerMinute,
+		Strategy:  Block,
+		Partition: PartitionFromContext(tenantKey{}),
+	})
+
+	url := "https://api.plan.com/v1/things"
+	free := context.WithValue(context.Background(), tenantKey{}, "free")
+	pro := context.WithValue(context.Background(), tenantKey{}, "pro")
+
+	if err := l.Check(free, url); err != nil {
+		t.Fatalf("free request 1: %v", err)
+	}
+	if err := l.Check(free, url); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("free request 2: expected ErrLimitExceeded, got %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := l.Check(pro, url); err != nil {
+			t.Fatalf("pro request %d: %v", i+1, err)
+		}
+	}
+	err := l.Check(pro, url)
+	var limErr *LimitExceededError
+	if !errors.As(err, &limErr) {
+		t.Fatalf("pro request 4: expected *LimitExceededError, got %v", err)
+	}
+	if limErr.Resource.Limit != 3 {
+		t.Errorf("error limit = %d, want 3", limErr.Resource.Limit)
+	}
+
+	// Overrides can change at runtime.
+	overrides.Set("plan-api", "free", 10)
+	if err := l.Check(free, url); err != nil {
+		t.Fatalf("free request after upgrade: %v", err)
+	}
+}
+
+func TestOverridesLoad(t *testing.T) {
+	s, err := store.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	s.SetOverride(ctx, store.LimitOverride{Resource: "api", Partition: "acme", Limit: 42})
+
+	o := NewOverrides()
+	o.Set("api", "stale", 1)
+	if err := o.Load(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, ok, _ := o.ResolveLimit(ctx, Resource{Name: "api"}, "acme"); !ok || got != 42 {
+		t.Errorf("acme = %d, %v; want 42, true", got, ok)
+	}
+	if _, ok, _ := o.ResolveLimit(ctx, Resource{Name: "api"}, "stale"); ok {
+		t.Error("stale override survived Load")
+	}
+}
+
+func TestLimiterQuotaGroup(t *testing.T) {
+	l := New()
+	l.Register(Resource{
+		Name:     "openai-org",
+		Limit:    3,
+		Window:   PerMinute,
+		Strategy: Block,
+	})
+	l.Register(Resource{
+		Name:     "openai-chat",
+		Pattern:  "api.openai.com/v1/chat/*",
+		Limit:    10,
+		Window:   PerMinute,
+		Strategy: Block,
+		Group:    "openai-org",
+	})
+	l.Register(Resource{
+		Name:     "openai-embeddings",
+		Pattern:  "api.openai.com/v1/embeddings",
+		Limit:    10,
+		Window:   PerMinute,
+		Strategy: Block,
+		Group:    "openai-org",
+	})
+
+	ctx := context.Background()
+	for _, url := range []string{
+		"https://api.openai.com/v1/chat/completions",
+		"https://api.openai.com/v1/embeddings",
+		"https://api.openai.com/v1/chat/completions",
+	} {
+		if err := l.Check(ctx, url); err != nil {
+			t.Fatalf("%s: %v", url, err)
+		}
+	}
+
+	// The shared budget is exhausted even though each resource is under its own limit.
+	err := l.Check(ctx, "https://api.openai.com/v1/embeddings")
+	var limErr *LimitExceededError
+	if !errors.As(err, &limErr) {
+		t.Fatalf("expected *LimitExceededError, got %v", err)
+	}
+	if limErr.Resource.Name != "openai-org" {
+		t.Errorf("resource = %q, want %q", limErr.Resource.Name, "openai-org")
+	}
+
+	statuses, err := l.Snapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 1 {
+		t.Fatalf("snapshot roots = %d, want 1", len(statuses))
+	}
+	org := statuses[0]
+	if org.Resource.Name != "openai-org" || org.Current != 4 {
+		t.Errorf("root = %s %d, want openai-org 4", org.Resource.Name, org.Current)
+	}
+	if len(org.Children) != 2 {
+		t.Fatalf("children = %d, want 2", len(org.Children))
+	}
+	if org.Children[0].Current != 2 || org.Children[1].Current != 2 {
+		t.Errorf("child counts = %d, %d; want 2, 2", org.Children[0].Current, org.Children[1].Current)
+	}
+}
+
+func TestLimiterUnknownGroup(t *testing.T) {
+	l := New()
+	l.Register(Resource{
+		Name:    "orphan",
+		Pattern: "api.orphan.com/*",
+		Limit:   10,
+		Window:  PerMinute,
+		Group:   "missing",
+	})
+
+	err := l.Check(context.Background(), "https://api.orphan.com/")
+	if err == nil || errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("expected configuration error, got %v", err)
+	}
+}
+
+func TestLimiterBudget(t *testing.T) {
+	l := New()
+	l.Register(Resource{
+		Name:     "twilio",
+		Pattern:  "api.twilio.com/*",
+		Window:   PerMonth,
+		Strategy: Block,
+		Budget:   Units(0.10),
+		Currency: "USD",
+		Price:    Units(0.04),
+	})
+
+	ctx := context.Background()
+	url := "https://api.twilio.com/2010-04-01/Messages.json"
+
+	for i := 0; i < 2; i++ {
+		if err := l.Check(ctx, url); err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+	}
+
+	// A third message would bring spend to 0.12, over the 0.10 budget.
+	err := l.Check(ctx, url)
+	var limErr *LimitExceededError
+	if !errors.As(err, &limErr) {
+		t.Fatalf("expected *LimitExceededError, got %v", err)
+	}
+	if limErr.Spent != Units(0.12) {
+		t.Errorf("spent = %s, want 0.120000", limErr.Spent)
+	}
+	if limErr.Remaining() != 0 {
+		t.Errorf("remaining = %s, want 0", limErr.Remaining())
+	}
+	if want := "erl: budget exceeded for twilio (0.120000/0.100000 USD)"; err.Error() != want {
+		t.Errorf("error = %q, want %q", err.Error(), want)
+	}
+
+	statuses, err := l.Snapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statuses[0].Spent != Units(0.12) || statuses[0].Remaining != 0 {
+		t.Errorf("snapshot spent = %s, remaining = %s", statuses[0].Spent, statuses[0].Remaining)
+	}
+
+	// Resetting usage clears the spend along with the call count.
+	if err := l.ResetUsage(ctx, "twilio"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Check(ctx, url); err != nil {
+		t.Fatalf("after reset: %v", err)
+	}
+}
+
+// batchStore reads counters only through GetMany, recording each batch.
+type batchStore struct {
+	*store.MemoryStore
+	batches [][]store.Read
+}
+
+func (s *batchStore) Get(context.Context, string, store.Window) (int64, error) {
+	return 0, errors.New("unexpected Get")
+}
+
+func (s *batchStore) GetMany(ctx context.Context, reads []store.Read) ([]int64, error) {
+	s.batches = append(s.batches, reads)
+	out := make([]int64, len(reads))
+	for i, rd := range reads {
+		n, err := s.MemoryStore.Get(ctx, rd.Key, rd.Window)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = n
+	}
+	return out, nil
+}
+
+// addStore supports weighted increments through store.Adder only.
+type addStore struct {
+	store.Store
+	mem *store.MemoryStore
+}
+
+func (s *addStore) Add(ctx context.Context, key string, w store.Window, delta int64) (int64, error) {
+	counts, err := s.mem.IncrementMany(ctx, []store.Increment{{Key: key, Window: w, Delta: delta}})
+	if err != nil {
+		return 0, err
+	}
+	return counts[0], nil
+}
+
+func TestLimiterChargesThroughAdder(t *testing.T) {
+	mem := store.NewMemoryStore()
+	l := New(WithStore(&addStore{Store: mem, mem: mem}))
+	l.Register(Resource{Name: "twilio", Pattern: "api.twilio.com/*", Window: PerMonth, Strategy: Block, Budget: Units(0.5), Price: Units(0.25)})
+
+	ctx := context.Background()
+	url := "https://api.twilio.com/2010-04-01/Messages.json"
+	for i := range 2 {
+		if err := l.Check(ctx, url); err != nil {
+			t.Fatalf("call %d: %v", i+1, err)
+		}
+	}
+	var limErr *LimitExceededError
+	if err := l.Check(ctx, url); !errors.As(err, &limErr) {
+		t.Fatalf("third call: err = %v, want *LimitExceededError", err)
+	}
+	if limErr.Spent != Units(0.75) {
+		t.Errorf("spent = %s, want 0.750000", limErr.Spent)
+	}
+}
+
+func TestLimiterSnapshotBatchesReads(t *testing.T) {
+	s := &batchStore{MemoryStore: store.NewMemoryStore()}
+	l := New(WithStore(s))
+	l.Register(Resource{Name: "stripe", Pattern: "api.stripe.com/*", Limit: 10, Window: PerMinute})
+	l.Register(Resource{Name: "twilio", Pattern: "api.twilio.com/*", Window: PerMonth, Budget: Units(1), Price: Units(0.25)})
+
+	ctx := context.Background()
+	l.Check(ctx, "https://api.stripe.com/v1/charges")
+	l.Check(ctx, "https://api.stripe.com/v1/charges")
+	l.Check(ctx, "https://api.twilio.com/2010-04-01/Messages.json")
+
+	statuses, err := l.Snapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.batches) != 1 || len(s.batches[0]) != 3 {
+		t.Fatalf("batches = %v, want one batch of 3 reads", s.batches)
+	}
+	if statuses[0].Current != 2 {
+		t.Errorf("stripe current = %d, want 2", statuses[0].Current)
+	}
+	if statuses[1].Current != 1 || statuses[1].Spent != Units(0.25) || statuses[1].Remaining != Units(0.75) {
+		t.Errorf("twilio = %d, spent %s, remaining %s", statuses[1].Current, statuses[1].Spent, statuses[1].Remaining)
+	}
+}
@@ -0,0 +1,147 @@
+package erl
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// ErrNoFallbackResponse is returned by LastResponse when it has no recorded
+// response for a request.
+var ErrNoFallbackResponse = errors.New("erl: no fallback response")
+
+// ResponseRecorder is implemented by Fallback round trippers that learn from
+// live traffic. Limiter.Transport passes every upstream response for the
+// resource to Record before returning it to the caller.
+type ResponseRecorder interface {
+	// Record observes a response. Implementations that read resp.Body must
+	// replace it with an equivalent unread body.
+	Record(req *http.Request, resp *http.Response) error
+}
+
+type recordedResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// LastResponse is a Fallback that serves the most recent successful GET
+// response for the requested URL. Use it as a resource's Fallback; the
+// transport records responses into it automatically. Responses are kept per
+// partition, or per Authorization header on unpartitioned resources, so one
+// client is never served another's response. It is safe for concurrent use.
+type LastResponse struct {
+	mu         sync.Mutex
+	maxEntries int
+	entries    map[string]*recordedResponse
+	order      []string // keys, oldest first
+}
+
+// NewLastResponse creates a LastResponse that keeps at most maxEntries URLs,
+// evicting the oldest. A maxEntries of zero or less means no limit.
+func NewLastResponse(maxEntries int) *LastResponse {
+	return &LastResponse{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*recordedResponse),
+	}
+}
+
+// Record stores successful GET responses.
+func (c *LastResponse) Record(req *http.Request, resp *http.Response) error {
+	if req.Method != http.MethodGet || resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	key := responseKey(req)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; !ok {
+		c.order = append(c.order, key)
+	}
+	c.entries[key] = &recordedResponse{
+		status: resp.StatusCode,
+		header: resp.Header.Clone(),
+		body:   body,
+	}
+
+	for c.maxEntries > 0 && len(c.order) > c.maxEntries {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+	return nil
+}
+
+// RoundTrip replays the last recorded response for the request URL and
+// client, or returns ErrNoFallbackResponse.
+func (c *LastResponse) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	rec, ok := c.entries[responseKey(req)]
+	c.mu.Unlock()
+	if !ok || req.Method != http.MethodGet {
+		return nil, ErrNoFallbackResponse
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", rec.status, http.StatusText(rec.status)),
+		StatusCode:    rec.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        rec.header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(rec.body)),
+		ContentLength: int64(len(rec.body)),
+		Request:       req,
+	}, nil
+}
+
+// responseKey identifies the recorded response for a request: its URL in
+// the scope of the client that made it.
+func responseKey(req *http.Request) string {
+	return responseScope(requestPartition(req), req) + " " + req.URL.String()
+}
+
+// responseScope returns which clients may share a stored response: those in
+// the same partition or, on unpartitioned resources, those sending the same
+// Authorization header.
+func responseScope(partition string, req *http.Request) string {
+	if partition != "" {
+		return "partition:" + partition
+	}
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		sum := sha256.Sum256([]byte(auth))
+		return "credentials:" + hex.EncodeToString(sum[:])
+	}
+	return ""
+}
+
+type partitionContextKey struct{}
+
+// withPartition returns req with its partition recorded in the context, for
+// fallbacks to read with requestPartition.
+func withPartition(req *http.Request, partition string) *http.Request {
+	if partition == "" {
+		return req
+	}
+	return req.WithContext(context.WithValue(req.Context(), partitionContextKey{}, partition))
+}
+
+// requestPartition returns the partition the transport routed req to.
+func requestPartition(req *http.Request) string {
+	p, _ := req.Context().Value(partitionContextKey{}).(string)
+	return p
+}
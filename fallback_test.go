@@ -0,0 +1,171 @@
+package erl
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTransportFallbackLastResponse(t *testing.T) {
+	var hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Write([]byte("rates v1"))
+	}))
+	defer srv.Close()
+
+	l := New()
+	l.Register(Resource{
+		Name:     "rates",
+		Pattern:  "*",
+		Limit:    1,
+		Window:   PerMinute,
+		Strategy: Fallback,
+		Fallback: NewLastResponse(10),
+	})
+
+	client := &http.Client{
+		Transport: l.Transport(nil),
+	}
+
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(srv.URL + "/rates")
+		if err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "rates v1" {
+			t.Errorf("request %d: body = %q, want %q", i+1, body, "rates v1")
+		}
+	}
+
+	if hits != 1 {
+		t.Errorf("upstream hits = %d, want 1", hits)
+	}
+
+	// A URL never seen before has nothing to fall back on.
+	_, err := client.Get(srv.URL + "/other")
+	if !errors.Is(err, ErrLimitExceeded) || !errors.Is(err, ErrNoFallbackResponse) {
+		t.Errorf("expected ErrLimitExceeded and ErrNoFallbackResponse, got %v", err)
+	}
+}
+
+func TestLastResponseEviction(t *testing.T) {
+	c := NewLastResponse(1)
+	for _, path := range []string{"/a", "/b"} {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com"+path, nil)
+		resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(http.NoBody)}
+		if err := c.Record(req, resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := c.RoundTrip(httptest.NewRequest(http.MethodGet, "http://example.com/a", nil)); !errors.Is(err, ErrNoFallbackResponse) {
+		t.Errorf("evicted entry: err = %v, want ErrNoFallbackResponse", err)
+	}
+	if _, err := c.RoundTrip(httptest.NewRequest(http.MethodGet, "http://example.com/b", nil)); err != nil {
+		t.Errorf("kept entry: %v", err)
+	}
+}
+
+func TestTransportFallbackPerPartition(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("report for " + r.Header.Get("X-Tenant") + r.Header.Get("Authorization")))
+	}))
+	defer srv.Close()
+
+	get := func(client *http.Client, name, value string) (string, error) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/report", nil)
+		req.Header.Set(name, value)
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body), nil
+	}
+
+	l := New()
+	l.Register(Resource{
+		Name:      "reports",
+		Pattern:   "*",
+		Limit:     1,
+		Window:    PerMinute,
+		Strategy:  Fallback,
+		Fallback:  NewLastResponse(10),
+		Partition: PartitionFromHeader("X-Tenant"),
+	})
+	client := &http.Client{Transport: l.Transport(nil)}
+
+	for _, tenant := range []string{"acme", "globex", "acme", "globex"} {
+		body, err := get(client, "X-Tenant", tenant)
+		if err != nil {
+			t.Fatalf("%s: %v", tenant, err)
+		}
+		if want := "report for " + tenant; body != want {
+			t.Errorf("%s: body = %q, want %q", tenant, body, want)
+		}
+	}
+
+	// Without a partition, responses are kept per credential.
+	shared := New()
+	shared.Register(Resource{
+		Name:     "reports",
+		Pattern:  "*",
+		Limit:    1,
+		Window:   PerMinute,
+		Strategy: Fallback,
+		Fallback: NewLastResponse(10),
+	})
+	client = &http.Client{Transport: shared.Transport(nil)}
+	if _, err := get(client, "Authorization", "Bearer a"); err != nil {
+		t.Fatal(err)
+	}
+	if body, err := get(client, "Authorization", "Bearer a"); err != nil || body != "report for Bearer a" {
+		t.Errorf("same credentials: body = %q, err = %v", body, err)
+	}
+	if body, err := get(client, "Authorization", "Bearer b"); !errors.Is(err, ErrNoFallbackResponse) {
+		t.Errorf("other credentials: body = %q, err = %v; want ErrNoFallbackResponse", body, err)
+	}
+}
+
+func TestTransportFallbackGroupBlocks(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte("repos"))
+	}))
+	defer srv.Close()
+
+	l := New()
+	l.Register(Resource{Name: "org", Limit: 1, Window: PerMinute, Strategy: Block})
+	l.Register(Resource{
+		Name:     "repos",
+		Pattern:  "*",
+		Limit:    10,
+		Window:   PerMinute,
+		Strategy: Fallback,
+		Fallback: NewLastResponse(0),
+		Group:    "org",
+	})
+	client := &http.Client{Transport: l.Transport(nil)}
+
+	for i := range 2 {
+		resp, err := client.Get(srv.URL + "/repos")
+		if err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "repos" {
+			t.Errorf("request %d: body = %q, want %q", i+1, body, "repos")
+		}
+	}
+	if calls != 1 {
+		t.Errorf("upstream calls = %d, want 1", calls)
+	}
+}
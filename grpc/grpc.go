@@ -0,0 +1,174 @@
+// Package grpc provides gRPC client interceptors that enforce erl rate
+// limits. Resources are matched against the full method name without its
+// leading slash, so a pattern such as
+// "google.cloud.vision.v1.ImageAnnotator/*" covers every method of a service:
+//
+//	conn, err := grpc.NewClient(target,
+//		grpc.WithUnaryInterceptor(erlgrpc.UnaryClientInterceptor(limiter)),
+//		grpc.WithStreamInterceptor(erlgrpc.StreamClientInterceptor(limiter)),
+//	)
+//
+// Refused calls fail with codes.ResourceExhausted when a limit is exceeded
+// and codes.Unavailable when a circuit breaker is open. Both carry an
+// errdetails.RetryInfo saying when to try again.
+package grpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ryhazerus/erl"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Option configures StreamClientInterceptor.
+type Option func(*options)
+
+type options struct {
+	perMessage bool
+}
+
+// WithPerMessage charges one call for every message sent on a stream instead
+// of one call when the stream is opened. Use it for APIs whose quotas count
+// streamed requests, such as streaming recognition.
+func WithPerMessage() Option {
+	return func(o *options) {
+		o.perMessage = true
+	}
+}
+
+// UnaryClientInterceptor returns an interceptor that charges one call to the
+// resource matching each method. Methods that match no resource are passed
+// through. When the resource has a circuit breaker, calls failing with
+// Unavailable, ResourceExhausted, Internal, Unknown or DeadlineExceeded
+// count as failures.
+func UnaryClientInterceptor(l *erl.Limiter) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		r, ok := l.Match(strings.TrimPrefix(method, "/"))
+		if !ok {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		var (
+			ran     bool
+			callErr error
+		)
+		err := l.Do(ctx, r.Name, func(ctx context.Context) error {
+			ran = true
+			callErr = invoker(ctx, method, req, reply, cc, opts...)
+			if failed(callErr) {
+				return callErr
+			}
+			return nil
+		})
+		if ran {
+			return callErr
+		}
+		return statusError(err)
+	}
+}
+
+// StreamClientInterceptor returns an interceptor that charges one call to the
+// resource matching each method when a stream is opened, or one call per
+// sent message with WithPerMessage. Methods that match no resource are
+// passed through. When the resource has a circuit breaker, an open breaker
+// refuses new streams and a stream that fails to open counts as a failure;
+// errors later in a stream are not recorded. With WithPerMessage, streams
+// bypass the breaker entirely.
+func StreamClientInterceptor(l *erl.Limiter, opts ...Option) grpc.StreamClientInterceptor {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+		r, ok := l.Match(strings.TrimPrefix(method, "/"))
+		if !ok {
+			return streamer(ctx, desc, cc, method, callOpts...)
+		}
+
+		if !o.perMessage {
+			var (
+				ran     bool
+				cs      grpc.ClientStream
+				openErr error
+			)
+			err := l.Do(ctx, r.Name, func(ctx context.Context) error {
+				ran = true
+				cs, openErr = streamer(ctx, desc, cc, method, callOpts...)
+				if failed(openErr) {
+					return openErr
+				}
+				return nil
+			})
+			if ran {
+				return cs, openErr
+			}
+			return nil, statusError(err)
+		}
+
+		cs, err := streamer(ctx, desc, cc, method, callOpts...)
+		if err != nil {
+			return nil, err
+		}
+		return &meteredStream{ClientStream: cs, limiter: l, name: r.Name}, nil
+	}
+}
+
+// meteredStream charges the limiter for every message sent.
+type meteredStream struct {
+	grpc.ClientStream
+	limiter *erl.Limiter
+	name    string
+}
+
+func (s *meteredStream) SendMsg(m any) error {
+	if err := s.limiter.Allow(s.Context(), s.name, 1); err != nil {
+		return statusError(err)
+	}
+	return s.ClientStream.SendMsg(m)
+}
+
+// failed reports whether a call error should count against a circuit
+// breaker. Errors describing the request itself, such as InvalidArgument or
+// NotFound, do not.
+func failed(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Internal, codes.Unknown, codes.DeadlineExceeded:
+		return true
+	default:
+		return false
+	}
+}
+
+// statusError converts erl refusals into gRPC status errors with retry
+// information. Other errors are returned unchanged.
+func statusError(err error) error {
+	var limErr *erl.LimitExceededError
+	if errors.As(err, &limErr) {
+		return retryStatus(codes.ResourceExhausted, err.Error(), limErr.ResetAt())
+	}
+	var openErr *erl.CircuitOpenError
+	if errors.As(err, &openErr) {
+		return retryStatus(codes.Unavailable, err.Error(), openErr.RetryAt)
+	}
+	return err
+}
+
+func retryStatus(code codes.Code, msg string, at time.Time) error {
+	st := status.New(code, msg)
+	delay := max(time.Until(at), 0)
+	if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(delay)}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
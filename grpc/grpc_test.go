@@ -0,0 +1,233 @@
+package grpc
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ryhazerus/erl"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// echoServer implements a test service described by hand so no generated
+// code is needed. Ping fails with pingErr; Upload reads messages until the
+// client closes its side.
+type echoServer struct {
+	pingErr error
+}
+
+var echoDesc = grpc.ServiceDesc{
+	ServiceName: "test.Echo",
+	HandlerType: (*any)(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Ping",
+		Handler: func(srv any, ctx context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+			if err := dec(new(emptypb.Empty)); err != nil {
+				return nil, err
+			}
+			return new(emptypb.Empty), srv.(*echoServer).pingErr
+		},
+	}},
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Upload",
+		ClientStreams: true,
+		Handler: func(_ any, ss grpc.ServerStream) error {
+			for {
+				if err := ss.RecvMsg(new(emptypb.Empty)); err == io.EOF {
+					return ss.SendMsg(new(emptypb.Empty))
+				} else if err != nil {
+					return err
+				}
+			}
+		},
+	}},
+}
+
+func newTestConn(t *testing.T, srv *echoServer, opts ...grpc.DialOption) *grpc.ClientConn {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	s.RegisterService(&echoDesc, srv)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	opts = append(opts,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	conn, err := grpc.NewClient("passthrough:///bufnet", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func ping(conn *grpc.ClientConn) error {
+	return conn.Invoke(context.Background(), "/test.Echo/Ping", new(emptypb.Empty), new(emptypb.Empty))
+}
+
+// upload opens an Upload stream and sends n messages on it.
+func upload(conn *grpc.ClientConn, n int) error {
+	cs, err := conn.NewStream(context.Background(), &echoDesc.Streams[0], "/test.Echo/Upload")
+	if err != nil {
+		return err
+	}
+	for range n {
+		if err := cs.SendMsg(new(emptypb.Empty)); err != nil {
+			return err
+		}
+	}
+	if err := cs.CloseSend(); err != nil {
+		return err
+	}
+	return cs.RecvMsg(new(emptypb.Empty))
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	l := erl.New()
+	l.Register(erl.Resource{Name: "echo", Pattern: "test.Echo/*", Limit: 2, Window: erl.PerMinute})
+	conn := newTestConn(t, &echoServer{}, grpc.WithUnaryInterceptor(UnaryClientInterceptor(l)))
+
+	for i := range 2 {
+		if err := ping(conn); err != nil {
+			t.Fatalf("call %d: %v", i+1, err)
+		}
+	}
+
+	err := ping(conn)
+	st := status.Convert(err)
+	if st.Code() != codes.ResourceExhausted {
+		t.Fatalf("code = %v, want ResourceExhausted", st.Code())
+	}
+	var info *errdetails.RetryInfo
+	for _, d := range st.Details() {
+		if ri, ok := d.(*errdetails.RetryInfo); ok {
+			info = ri
+		}
+	}
+	if info == nil {
+		t.Fatal("missing RetryInfo detail")
+	}
+	if d := info.RetryDelay.AsDuration(); d <= 0 || d > time.Minute {
+		t.Errorf("retry delay = %v, want within the window", d)
+	}
+}
+
+func TestUnaryClientInterceptorUnmatched(t *testing.T) {
+	l := erl.New()
+	l.Register(erl.Resource{Name: "other", Pattern: "test.Other/*", Limit: 1, Window: erl.PerMinute})
+	conn := newTestConn(t, &echoServer{}, grpc.WithUnaryInterceptor(UnaryClientInterceptor(l)))
+
+	for i := range 3 {
+		if err := ping(conn); err != nil {
+			t.Fatalf("call %d: %v", i+1, err)
+		}
+	}
+}
+
+func TestUnaryClientInterceptorBreaker(t *testing.T) {
+	l := erl.New()
+	l.Register(erl.Resource{
+		Name:    "echo",
+		Pattern: "test.Echo/Ping",
+		Limit:   100,
+		Window:  erl.PerMinute,
+		Breaker: &erl.BreakerPolicy{MinRequests: 2, OpenFor: time.Minute},
+	})
+	srv := &echoServer{pingErr: status.Error(codes.InvalidArgument, "bad request")}
+	conn := newTestConn(t, srv, grpc.WithUnaryInterceptor(UnaryClientInterceptor(l)))
+
+	// Errors about the request itself do not trip the breaker.
+	for range 3 {
+		if code := status.Code(ping(conn)); code != codes.InvalidArgument {
+			t.Fatalf("code = %v, want InvalidArgument", code)
+		}
+	}
+
+	srv.pingErr = status.Error(codes.Unavailable, "backend down")
+	for range 3 {
+		ping(conn)
+	}
+	err := ping(conn)
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("err = %v, want Unavailable", err)
+	}
+	if msg := status.Convert(err).Message(); msg != "erl: circuit open for echo" {
+		t.Errorf("message = %q, want breaker refusal", msg)
+	}
+}
+
+func TestStreamClientInterceptor(t *testing.T) {
+	l := erl.New()
+	l.Register(erl.Resource{Name: "echo", Pattern: "test.Echo/*", Limit: 2, Window: erl.PerMinute})
+	conn := newTestConn(t, &echoServer{}, grpc.WithStreamInterceptor(StreamClientInterceptor(l)))
+
+	// Each stream counts once however many messages it sends.
+	for i := range 2 {
+		if err := upload(conn, 5); err != nil {
+			t.Fatalf("stream %d: %v", i+1, err)
+		}
+	}
+	if code := status.Code(upload(conn, 1)); code != codes.ResourceExhausted {
+		t.Errorf("code = %v, want ResourceExhausted", code)
+	}
+}
+
+func TestStreamClientInterceptorBreaker(t *testing.T) {
+	l := erl.New()
+	l.Register(erl.Resource{
+		Name:    "echo",
+		Pattern: "test.Echo/*",
+		Limit:   100,
+		Window:  erl.PerMinute,
+		Breaker: &erl.BreakerPolicy{MinRequests: 2, OpenFor: time.Minute},
+	})
+	srv := &echoServer{pingErr: status.Error(codes.Unavailable, "backend down")}
+	conn := newTestConn(t, srv,
+		grpc.WithUnaryInterceptor(UnaryClientInterceptor(l)),
+		grpc.WithStreamInterceptor(StreamClientInterceptor(l)),
+	)
+
+	for range 2 {
+		ping(conn)
+	}
+	err := upload(conn, 1)
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("err = %v, want Unavailable", err)
+	}
+	if msg := status.Convert(err).Message(); msg != "erl: circuit open for echo" {
+		t.Errorf("message = %q, want breaker refusal", msg)
+	}
+}
+
+func TestStreamClientInterceptorPerMessage(t *testing.T) {
+	l := erl.New()
+	l.Register(erl.Resource{Name: "echo", Pattern: "test.Echo/*", Limit: 3, Window: erl.PerMinute})
+	conn := newTestConn(t, &echoServer{}, grpc.WithStreamInterceptor(StreamClientInterceptor(l, WithPerMessage())))
+
+	if err := upload(conn, 3); err != nil {
+		t.Fatal(err)
+	}
+	if code := status.Code(upload(conn, 1)); code != codes.ResourceExhausted {
+		t.Errorf("code = %v, want ResourceExhausted", code)
+	}
+
+	usage, err := l.GetUsage(context.Background(), "echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage != 4 {
+		t.Errorf("usage = %d, want 4", usage)
+	}
+}
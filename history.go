@@ -0,0 +1,39 @@
+package erl
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ryhazerus/erl/store"
+)
+
+// History returns a resource's usage in each window bucket that started in
+// [from, to), oldest first, including the active bucket. Past buckets are only
+// available from stores that archive them: SQLite, PostgreSQL and Redis with
+// their WithHistory option, or a tiered store over one of them.
+func (l *Limiter) History(ctx context.Context, name string, from, to time.Time) ([]store.Bucket, error) {
+	return l.PartitionHistory(ctx, name, "", from, to)
+}
+
+// PartitionHistory returns the usage of one partition of a resource in each
+// window bucket that started in [from, to), oldest first.
+func (l *Limiter) PartitionHistory(ctx context.Context, name, partition string, from, to time.Time) ([]store.Bucket, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	r, ok := l.lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("erl: resource %q not found", name)
+	}
+	h, ok := store.AsHistoryStore(l.store)
+	if !ok {
+		return nil, fmt.Errorf("erl: %T does not keep history", l.store)
+	}
+
+	buckets, err := h.History(ctx, counterKey(r.Name, partition), from, to)
+	if err != nil {
+		return nil, fmt.Errorf("erl: history %s: %w", r.Name, err)
+	}
+	return buckets, nil
+}
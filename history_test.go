@@ -0,0 +1,71 @@
+package erl
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ryhazerus/erl/store"
+)
+
+func TestLimiterHistory(t *testing.T) {
+	s, err := store.NewSQLiteStore(":memory:", store.WithHistory(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := New(WithStore(s))
+	defer l.Close()
+	l.Register(Resource{Name: "stripe", Pattern: "api.stripe.com/*", Limit: 100, Window: PerMinute})
+
+	ctx := context.Background()
+	now := time.Now()
+	past := PerMinute.storeWindow(now.Add(-time.Minute))
+	for range 3 {
+		s.Increment(ctx, "stripe", past)
+	}
+	s.Increment(ctx, "stripe:acme", past)
+	for range 2 {
+		if err := l.Check(ctx, "https://api.stripe.com/v1/charges"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := l.History(ctx, "stripe", now.Add(-time.Hour), now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("history = %+v, want 2 buckets", got)
+	}
+	if got[0].Key != past.BucketKey || got[0].Count != 3 {
+		t.Errorf("past bucket = %+v, want %s with 3", got[0], past.BucketKey)
+	}
+	if got[1].Key != PerMinute.BucketKey(now) || got[1].Count != 2 {
+		t.Errorf("current bucket = %+v, want %s with 2", got[1], PerMinute.BucketKey(now))
+	}
+
+	got, err = l.PartitionHistory(ctx, "stripe", "acme", now.Add(-time.Hour), now.Add(time.Minute))
+	if err != nil || len(got) != 1 || got[0].Count != 1 {
+		t.Errorf("partition history = %+v, %v", got, err)
+	}
+
+	if _, err := l.History(ctx, "missing", now.Add(-time.Hour), now); err == nil {
+		t.Error("expected an error for an unknown resource")
+	}
+}
+
+func TestLimiterHistoryUnsupported(t *testing.T) {
+	l := New()
+	l.Register(Resource{Name: "stripe", Pattern: "api.stripe.com/*", Limit: 100, Window: PerMinute})
+
+	if _, err := l.History(context.Background(), "stripe", time.Time{}, time.Now()); err == nil {
+		t.Error("expected an error from a store without history")
+	}
+
+	// A tiered store keeps history only if its persistent store does.
+	tiered := New(WithStore(store.NewTieredStore(store.NewMemoryStore())))
+	tiered.Register(Resource{Name: "stripe", Pattern: "api.stripe.com/*", Limit: 100, Window: PerMinute})
+	if _, err := tiered.History(context.Background(), "stripe", time.Time{}, time.Now()); err == nil {
+		t.Error("expected an error from a tiered store without history")
+	}
+}
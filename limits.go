@@ -0,0 +1,84 @@
+package erl
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ryhazerus/erl/store"
+)
+
+// LimitResolver looks up the effective limit for one partition of a resource,
+// e.g. from the tenant's plan. ok is false when the resource's own Limit
+// applies.
+type LimitResolver interface {
+	ResolveLimit(ctx context.Context, r Resource, partition string) (limit int64, ok bool, err error)
+}
+
+// LimitResolverFunc adapts an ordinary function to the LimitResolver interface.
+type LimitResolverFunc func(ctx context.Context, r Resource, partition string) (int64, bool, error)
+
+// ResolveLimit calls f(ctx, r, partition).
+func (f LimitResolverFunc) ResolveLimit(ctx context.Context, r Resource, partition string) (int64, bool, error) {
+	return f(ctx, r, partition)
+}
+
+type overrideKey struct {
+	resource  string
+	partition string
+}
+
+// Overrides is a LimitResolver backed by an in-memory table keyed by resource
+// name and partition. It is safe for concurrent use and may be changed while
+// the limiter is serving requests.
+type Overrides struct {
+	mu     sync.RWMutex
+	limits map[overrideKey]int64
+}
+
+// NewOverrides creates an empty override table.
+func NewOverrides() *Overrides {
+	return &Overrides{
+		limits: make(map[overrideKey]int64),
+	}
+}
+
+// Set overrides the limit of a resource for one partition.
+func (o *Overrides) Set(name, partition string, limit int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.limits[overrideKey{name, partition}] = limit
+}
+
+// Delete removes the override for a resource partition.
+func (o *Overrides) Delete(name, partition string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.limits, overrideKey{name, partition})
+}
+
+// ResolveLimit returns the override for the resource partition, if any.
+func (o *Overrides) ResolveLimit(_ context.Context, r Resource, partition string) (int64, bool, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	limit, ok := o.limits[overrideKey{r.Name, partition}]
+	return limit, ok, nil
+}
+
+// Load replaces the table with the overrides persisted in src. It can be
+// called periodically to pick up changes made by other instances.
+func (o *Overrides) Load(ctx context.Context, src store.OverrideStore) error {
+	stored, err := src.Overrides(ctx)
+	if err != nil {
+		return err
+	}
+
+	limits := make(map[overrideKey]int64, len(stored))
+	for _, so := range stored {
+		limits[overrideKey{so.Resource, so.Partition}] = so.Limit
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.limits = limits
+	return nil
+}
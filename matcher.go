@@ -2,28 +2,141 @@ package erl
 
 import (
 	"net/url"
+	"regexp/syntax"
 	"strings"
 )
 
-// matchURL checks whether a request URL matches a resource's glob-style pattern.
-// Matching is performed against host + path of the URL.
-//
-// Supported patterns:
-//   - "api.stripe.com/*" matches any path on that host
-//   - "api.openai.com/v1/chat/*" matches only chat endpoints
-//   - "api.example.com/v1/specific" exact match
-func matchURL(rawURL string, pattern string) bool {
+// MatchMode controls which resource is selected when several patterns match
+// the same URL. Resources with a higher Priority always win; the mode only
+// breaks ties between resources of equal priority.
+type MatchMode int
+
+const (
+	// FirstMatch selects the earliest registered resource.
+	FirstMatch MatchMode = iota
+	// MostSpecific selects the most specific pattern: exact matches beat
+	// templates (patterns with an inner "*"), which beat regular
+	// expressions, which beat prefix wildcards ("/*"), which beat patterns
+	// with a wildcard in the host such as "*.stripe.com/*". The longest
+	// literal part wins within the same class; registration order settles
+	// any remaining tie.
+	MostSpecific
+)
+
+func (m MatchMode) String() string {
+	switch m {
+	case FirstMatch:
+		return "FirstMatch"
+	case MostSpecific:
+		return "MostSpecific"
+	default:
+		return "Unknown"
+	}
+}
+
+// Specificity classes, from least to most specific.
+const (
+	specCatchAll = iota
+	specHostWildcard
+	specPrefix
+	specRegexp
+	specTemplate
+	specExact
+)
+
+// hostPath returns the host + path of rawURL with trailing slashes stripped,
+// which is the value patterns and regular expressions are matched against.
+func hostPath(rawURL string) (string, bool) {
 	parsed, err := url.Parse(rawURL)
 	if err != nil {
+		return "", false
+	}
+	// Strip trailing slashes for consistency.
+	return strings.TrimRight(parsed.Host+parsed.Path, "/"), true
+}
+
+// matches reports whether the resource applies to the normalised host + path.
+func (r Resource) matches(hp string) bool {
+	if r.Regexp != nil {
+		return r.Regexp.MatchString(hp)
+	}
+	if r.Pattern == "" {
 		return false
 	}
+	return globMatch(strings.TrimRight(r.Pattern, "/"), hp)
+}
 
-	hostPath := parsed.Host + parsed.Path
-	// Strip trailing slashes for consistency.
-	hostPath = strings.TrimRight(hostPath, "/")
-	pattern = strings.TrimRight(pattern, "/")
+// specificity ranks how narrowly a resource's pattern selects URLs. It returns
+// the pattern class and the number of literal characters in the pattern or
+// regular expression; wildcards and regexp operators don't count.
+func (r Resource) specificity() (class, literal int) {
+	if r.Regexp != nil {
+		re, err := syntax.Parse(r.Regexp.String(), syntax.Perl)
+		if err != nil {
+			return specRegexp, 0
+		}
+		return specRegexp, literalLen(re.Simplify())
+	}
+
+	pattern := strings.TrimRight(r.Pattern, "/")
+	literal = len(pattern) - strings.Count(pattern, "*")
+	host, _, _ := strings.Cut(pattern, "/")
+	switch {
+	case pattern == "*":
+		return specCatchAll, 0
+	case !strings.Contains(pattern, "*"):
+		return specExact, literal
+	case strings.Contains(host, "*"):
+		return specHostWildcard, literal
+	case strings.HasSuffix(pattern, "/*") && !strings.Contains(strings.TrimSuffix(pattern, "/*"), "*"):
+		return specPrefix, literal
+	default:
+		return specTemplate, literal
+	}
+}
 
-	return globMatch(pattern, hostPath)
+// literalLen returns the number of literal characters every match of re
+// contains.
+func literalLen(re *syntax.Regexp) int {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return len(re.Rune)
+	case syntax.OpCapture, syntax.OpPlus:
+		return literalLen(re.Sub[0])
+	case syntax.OpRepeat:
+		return re.Min * literalLen(re.Sub[0])
+	case syntax.OpConcat:
+		n := 0
+		for _, sub := range re.Sub {
+			n += literalLen(sub)
+		}
+		return n
+	case syntax.OpAlternate:
+		n := literalLen(re.Sub[0])
+		for _, sub := range re.Sub[1:] {
+			n = min(n, literalLen(sub))
+		}
+		return n
+	default:
+		return 0
+	}
+}
+
+// preferred reports whether a should be selected over b when both match.
+func (m MatchMode) preferred(a, b Resource) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	if m != MostSpecific {
+		return false
+	}
+
+	ac, al := a.specificity()
+	bc, bl := b.specificity()
+	if ac != bc {
+		return ac > bc
+	}
+	return al > bl
 }
 
 // globMatch performs simple glob matching where "*" matches any sequence of
@@ -1,6 +1,9 @@
 package erl
 
-import "testing"
+import (
+	"regexp"
+	"testing"
+)
 
 func TestMatchURL(t *testing.T) {
 	tests := []struct {
@@ -67,10 +70,115 @@ func TestMatchURL(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := matchURL(tt.url, tt.pattern)
+			hp, ok := hostPath(tt.url)
+			got := ok && Resource{Pattern: tt.pattern}.matches(hp)
 			if got != tt.want {
-				t.Errorf("matchURL(%q, %q) = %v, want %v", tt.url, tt.pattern, got, tt.want)
+				t.Errorf("match(%q, %q) = %v, want %v", tt.url, tt.pattern, got, tt.want)
 			}
 		})
 	}
 }
+
+func TestMatchSelection(t *testing.T) {
+	resources := []Resource{
+		{Name: "catch-all", Pattern: "*"},
+		{Name: "subdomains", Pattern: "*.github.com/*"},
+		{Name: "host", Pattern: "api.github.com/*"},
+		{Name: "repos", Pattern: "api.github.com/repos/*"},
+		{Name: "issues", Pattern: "api.github.com/repos/*/*/issues"},
+		{Name: "numbered", Regexp: regexp.MustCompile(`^api\.github\.com/repos/[^/]+/[^/]+/issues/\d+$`)},
+		{Name: "rate-limit", Pattern: "api.github.com/rate_limit"},
+	}
+
+	tests := []struct {
+		name string
+		mode MatchMode
+		url  string
+		want string
+	}{
+		{"first match", FirstMatch, "https://api.github.com/repos/a/b/issues", "catch-all"},
+		{"specific exact", MostSpecific, "https://api.github.com/rate_limit", "rate-limit"},
+		{"specific template", MostSpecific, "https://api.github.com/repos/a/b/issues", "issues"},
+		{"specific regexp", MostSpecific, "https://api.github.com/repos/a/b/issues/42", "numbered"},
+		{"specific longer prefix", MostSpecific, "https://api.github.com/repos/a/b", "repos"},
+		{"specific host prefix", MostSpecific, "https://api.github.com/users", "host"},
+		{"specific host wildcard", MostSpecific, "https://uploads.github.com/repos/a/b", "subdomains"},
+		{"specific catch-all", MostSpecific, "https://example.com/", "catch-all"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(WithMatchMode(tt.mode))
+			for _, r := range resources {
+				l.Register(r)
+			}
+			got, ok := l.match(tt.url)
+			if !ok {
+				t.Fatalf("match(%q): no resource", tt.url)
+			}
+			if got.Name != tt.want {
+				t.Errorf("match(%q) = %q, want %q", tt.url, got.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchPriority(t *testing.T) {
+	for _, mode := range []MatchMode{FirstMatch, MostSpecific} {
+		l := New(WithMatchMode(mode))
+		l.Register(Resource{Name: "exact", Pattern: "api.stripe.com/v1/charges"})
+		l.Register(Resource{Name: "global", Pattern: "*", Priority: 10})
+
+		got, ok := l.match("https://api.stripe.com/v1/charges")
+		if !ok || got.Name != "global" {
+			t.Errorf("%s: match = %q, want %q", mode, got.Name, "global")
+		}
+	}
+}
+
+func TestLimiterMatchTarget(t *testing.T) {
+	l := New(WithMatchMode(MostSpecific))
+	l.Register(Resource{Name: "vision", Pattern: "google.cloud.vision.v1.ImageAnnotator/*"})
+	l.Register(Resource{Name: "batch", Pattern: "google.cloud.vision.v1.ImageAnnotator/BatchAnnotateImages"})
+
+	if r, ok := l.Match("google.cloud.vision.v1.ImageAnnotator/BatchAnnotateImages"); !ok || r.Name != "batch" {
+		t.Errorf("Match = %q, %v; want batch", r.Name, ok)
+	}
+	if r, ok := l.Match("google.cloud.vision.v1.ImageAnnotator/AsyncBatchAnnotateFiles"); !ok || r.Name != "vision" {
+		t.Errorf("Match = %q, %v; want vision", r.Name, ok)
+	}
+	if _, ok := l.Match("google.cloud.speech.v1.Speech/Recognize"); ok {
+		t.Error("unexpected match for another service")
+	}
+}
+
+func TestMatchLiteralPrefixBeatsHostWildcard(t *testing.T) {
+	for _, order := range [][]Resource{
+		{{Name: "stripe", Pattern: "*.stripe.com/*"}, {Name: "charges", Pattern: "api.stripe.com/v1/charges/*"}},
+		{{Name: "charges", Pattern: "api.stripe.com/v1/charges/*"}, {Name: "stripe", Pattern: "*.stripe.com/*"}},
+	} {
+		l := New(WithMatchMode(MostSpecific))
+		for _, r := range order {
+			l.Register(r)
+		}
+		if got, _ := l.match("https://api.stripe.com/v1/charges/ch_123"); got.Name != "charges" {
+			t.Errorf("charge matched %q, want charges", got.Name)
+		}
+		if got, _ := l.match("https://files.stripe.com/v1/files"); got.Name != "stripe" {
+			t.Errorf("file matched %q, want stripe", got.Name)
+		}
+	}
+}
+
+func TestMatchTemplateBeatsLongerPrefix(t *testing.T) {
+	l := New(WithMatchMode(MostSpecific))
+	l.Register(Resource{Name: "repo", Pattern: "api.github.com/repos/octo/hello-world/*"})
+	l.Register(Resource{Name: "issues", Pattern: "api.github.com/repos/*/issues"})
+
+	if got, _ := l.match("https://api.github.com/repos/octo/hello-world/issues"); got.Name != "issues" {
+		t.Errorf("issues matched %q, want the template", got.Name)
+	}
+	if got, _ := l.match("https://api.github.com/repos/octo/hello-world/pulls"); got.Name != "repo" {
+		t.Errorf("pulls matched %q, want the prefix", got.Name)
+	}
+}
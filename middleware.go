@@ -0,0 +1,117 @@
+package erl
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MiddlewareOption configures Limiter.Middleware.
+type MiddlewareOption func(*middleware)
+
+// WithClientKey sets how Middleware identifies the client of an inbound
+// request for resources without a PartitionFunc of their own. The default
+// is the client IP from the request's RemoteAddr.
+//
+// Keys taken from request headers, such as PartitionFromHeaderHash on
+// Authorization, are only safe when the header has been authenticated before
+// the middleware runs: otherwise a client can send a new made-up value with
+// every request and never reach its limit.
+func WithClientKey(fn PartitionFunc) MiddlewareOption {
+	return func(m *middleware) {
+		m.clientKey = fn
+	}
+}
+
+// middleware implements http.Handler and checks rate limits before passing
+// inbound requests to the next handler.
+type middleware struct {
+	limiter   *Limiter
+	next      http.Handler
+	clientKey PartitionFunc
+}
+
+// Middleware returns an http.Handler that enforces the limiter's resources on
+// requests received by a server before passing them to next. Requests are
+// matched on their Host and path, and resources without a PartitionFunc are
+// counted per client (see WithClientKey). Responses carry RateLimit-Limit,
+// RateLimit-Remaining and RateLimit-Reset headers for the tightest call
+// limit, and refused requests get 429 Too Many Requests with Retry-After.
+// Unmatched requests and LogOnly resources are always passed through.
+func (l *Limiter) Middleware(next http.Handler, opts ...MiddlewareOption) http.Handler {
+	m := &middleware{
+		limiter:   l,
+		next:      next,
+		clientKey: PartitionFromClientIP(),
+	}
+	for _, o := range opts {
+		o(m)
+	}
+	return m
+}
+
+func (m *middleware) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	charges, err := m.limiter.admit(req, m.clientKey)
+	now := time.Now()
+	rateLimitHeaders(w.Header(), charges, now)
+
+	var limErr *LimitExceededError
+	switch {
+	case errors.As(err, &limErr):
+		w.Header().Set("Retry-After", strconv.FormatInt(seconds(limErr.ResetAt().Sub(now)), 10))
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+	case err != nil:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	default:
+		m.next.ServeHTTP(w, req)
+	}
+}
+
+// admit matches an inbound request on its Host and path and applies the
+// resource's limits, partitioning by clientKey when the resource has no
+// PartitionFunc. Quota groups keep their own partitioning.
+func (l *Limiter) admit(req *http.Request, clientKey PartitionFunc) ([]charge, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	r, ok := l.matchTarget(strings.TrimRight(req.Host+req.URL.Path, "/"))
+	if !ok {
+		return nil, nil
+	}
+	if r.Partition == nil {
+		r.Partition = clientKey
+	}
+	return l.apply(req.Context(), r, req, 1)
+}
+
+// rateLimitHeaders describes the charge with the fewest calls left.
+func rateLimitHeaders(h http.Header, charges []charge, now time.Time) {
+	var tightest *charge
+	for i := range charges {
+		c := &charges[i]
+		if !c.resource.countLimited() {
+			continue
+		}
+		if tightest == nil || c.resource.Limit-c.current < tightest.resource.Limit-tightest.current {
+			tightest = c
+		}
+	}
+	if tightest == nil {
+		return
+	}
+
+	reset := tightest.window.BucketStart.Add(tightest.window.Duration)
+	h.Set("RateLimit-Limit", strconv.FormatInt(tightest.resource.Limit, 10))
+	h.Set("RateLimit-Remaining", strconv.FormatInt(max(0, tightest.resource.Limit-tightest.current), 10))
+	h.Set("RateLimit-Reset", strconv.FormatInt(seconds(reset.Sub(now)), 10))
+}
+
+// seconds rounds d up to whole seconds, as used by rate limit headers.
+func seconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64((d + time.Second - 1) / time.Second)
+}
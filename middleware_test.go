@@ -0,0 +1,132 @@
+package erl
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func serve(h http.Handler, target, remoteAddr, auth string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.RemoteAddr = remoteAddr
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMiddleware(t *testing.T) {
+	l := New()
+	l.Register(Resource{Name: "api", Pattern: "api.example.com/v1/*", Limit: 2, Window: PerMinute})
+
+	var served int
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served++
+	}))
+
+	for i := range 2 {
+		rec := serve(h, "http://api.example.com/v1/items", "192.0.2.1:1234", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want 200", i+1, rec.Code)
+		}
+		if got := rec.Header().Get("RateLimit-Remaining"); got != strconv.Itoa(1-i) {
+			t.Errorf("request %d: RateLimit-Remaining = %q, want %d", i+1, got, 1-i)
+		}
+		if got := rec.Header().Get("RateLimit-Limit"); got != "2" {
+			t.Errorf("RateLimit-Limit = %q, want 2", got)
+		}
+	}
+
+	rec := serve(h, "http://api.example.com/v1/items", "192.0.2.1:1234", "")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", rec.Code)
+	}
+	retry, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+	if err != nil || retry <= 0 || retry > 60 {
+		t.Errorf("Retry-After = %q, want 1-60 seconds", rec.Header().Get("Retry-After"))
+	}
+	if rec.Header().Get("RateLimit-Reset") == "" {
+		t.Error("missing RateLimit-Reset")
+	}
+	if served != 2 {
+		t.Errorf("served = %d, want 2", served)
+	}
+
+	// Another client has its own counter.
+	if rec := serve(h, "http://api.example.com/v1/items", "192.0.2.2:1234", ""); rec.Code != http.StatusOK {
+		t.Errorf("other client: status = %d, want 200", rec.Code)
+	}
+	// Unmatched paths are not limited.
+	if rec := serve(h, "http://api.example.com/health", "192.0.2.1:1234", ""); rec.Code != http.StatusOK {
+		t.Errorf("unmatched: status = %d, want 200", rec.Code)
+	}
+
+	usage, err := l.GetPartitionUsage(context.Background(), "api", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage != 3 {
+		t.Errorf("usage = %d, want 3", usage)
+	}
+}
+
+func TestMiddlewareClientKey(t *testing.T) {
+	l := New()
+	l.Register(Resource{Name: "api", Pattern: "*", Limit: 1, Window: PerMinute})
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// By default a client is its address: new made-up tokens do not get it
+	// past its limit.
+	if rec := serve(h, "/a", "192.0.2.1:1", "Bearer alice"); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if rec := serve(h, "/a", "192.0.2.1:1", "Bearer mallory"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("same address, new key: status = %d, want 429", rec.Code)
+	}
+
+	// Authenticated credentials can identify the client instead.
+	h = l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		WithClientKey(PartitionFromHeaderHash("Authorization")))
+	if rec := serve(h, "/a", "192.0.2.3:1", "Bearer carol"); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if rec := serve(h, "/a", "192.0.2.4:1", "Bearer carol"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("same key, new address: status = %d, want 429", rec.Code)
+	}
+	if rec := serve(h, "/a", "192.0.2.3:1", "Bearer dave"); rec.Code != http.StatusOK {
+		t.Errorf("other key: status = %d, want 200", rec.Code)
+	}
+
+	h = l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		WithClientKey(PartitionFromHeader("X-Tenant")))
+	req := httptest.NewRequest(http.MethodGet, "/a", nil)
+	req.Header.Set("X-Tenant", "acme")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	usage, err := l.GetPartitionUsage(context.Background(), "api", "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage != 1 {
+		t.Errorf("acme usage = %d, want 1", usage)
+	}
+}
+
+func TestMiddlewareLogOnly(t *testing.T) {
+	l := New()
+	l.Register(Resource{Name: "api", Pattern: "*", Limit: 1, Window: PerMinute, Strategy: LogOnly})
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve(h, "/a", "192.0.2.1:1", "")
+	rec := serve(h, "/a", "192.0.2.1:1", "")
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200", rec.Code)
+	}
+	if got := rec.Header().Get("RateLimit-Remaining"); got != "0" {
+		t.Errorf("RateLimit-Remaining = %q, want 0", got)
+	}
+}
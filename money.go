@@ -0,0 +1,43 @@
+package erl
+
+import (
+	"fmt"
+	"math"
+)
+
+// Money is a currency amount in integer micro-units, one millionth of the
+// currency's main unit. For a USD budget, Money(1_500_000) is $1.50.
+type Money int64
+
+// Unit is one whole unit of currency, e.g. one dollar.
+const Unit Money = 1_000_000
+
+// Units converts a decimal amount of currency to Money, rounding to the
+// nearest micro-unit. Units(0.0075) is three quarters of a cent.
+func Units(v float64) Money {
+	return Money(math.Round(v * float64(Unit)))
+}
+
+// String formats the amount with six decimal places, e.g. "1.500000".
+func (m Money) String() string {
+	sign := ""
+	if m < 0 {
+		sign = "-"
+		m = -m
+	}
+	return fmt.Sprintf("%s%d.%06d", sign, m/Unit, m%Unit)
+}
+
+// spendKey returns the store key for the spend counter kept alongside the
+// call counter with the given key.
+func spendKey(key string) string {
+	return key + "#spend"
+}
+
+// remaining returns how much of budget is left after spent, never negative.
+func remaining(budget, spent Money) Money {
+	if spent >= budget {
+		return 0
+	}
+	return budget - spent
+}
@@ -0,0 +1,26 @@
+package erl
+
+import "testing"
+
+func TestMoney(t *testing.T) {
+	tests := []struct {
+		in   float64
+		want Money
+		str  string
+	}{
+		{1.5, 1_500_000, "1.500000"},
+		{0.0075, 7_500, "0.007500"},
+		{500, 500 * Unit, "500.000000"},
+		{-0.25, -250_000, "-0.250000"},
+	}
+
+	for _, tt := range tests {
+		got := Units(tt.in)
+		if got != tt.want {
+			t.Errorf("Units(%v) = %d, want %d", tt.in, got, tt.want)
+		}
+		if got.String() != tt.str {
+			t.Errorf("Units(%v).String() = %q, want %q", tt.in, got.String(), tt.str)
+		}
+	}
+}
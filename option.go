@@ -21,3 +21,53 @@ func WithOnLimitReached(fn func(Resource, int64)) Option {
 		l.onLimitReached = fn
 	}
 }
+
+// WithMatchMode sets how the limiter chooses between several resources whose
+// patterns match the same URL. The default is FirstMatch.
+func WithMatchMode(m MatchMode) Option {
+	return func(l *Limiter) {
+		l.matchMode = m
+	}
+}
+
+// WithLimitResolver sets a resolver that is consulted on every check for the
+// effective limit of the matched resource and partition. Use an *Overrides
+// table for per-tenant limits or a LimitResolverFunc for plan tiers.
+func WithLimitResolver(r LimitResolver) Option {
+	return func(l *Limiter) {
+		l.limits = r
+	}
+}
+
+// WithOnThreshold sets a callback that fires when a resource's usage first
+// crosses one of its Thresholds in a window.
+func WithOnThreshold(fn func(ThresholdEvent)) Option {
+	return func(l *Limiter) {
+		l.onThreshold = fn
+	}
+}
+
+// WithDryRun evaluates every resource without blocking any request. Requests
+// the live limits would have refused are recorded as shadow denials, reported
+// in Snapshot and passed to the OnShadowDenied callback.
+func WithDryRun() Option {
+	return func(l *Limiter) {
+		l.dryRun = true
+	}
+}
+
+// WithOnShadowDenied sets a callback that fires for every request a Shadow
+// limit (or, in a dry run, a live limit) would have blocked.
+func WithOnShadowDenied(fn func(Resource, int64)) Option {
+	return func(l *Limiter) {
+		l.onShadowDenied = fn
+	}
+}
+
+// WithOnBreakerStateChange sets a callback that fires whenever a resource's
+// circuit breaker changes state.
+func WithOnBreakerStateChange(fn func(r Resource, from, to BreakerState)) Option {
+	return func(l *Limiter) {
+		l.onBreakerChange = fn
+	}
+}
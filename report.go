@@ -0,0 +1,257 @@
+package erl
+
+import (
+	"cmp"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ryhazerus/erl/store"
+)
+
+// ReportOptions selects what a usage report covers.
+type ReportOptions struct {
+	// From and To bound the report: window buckets that started in
+	// [From, To) are counted.
+	From, To time.Time
+
+	// Period groups buckets into report rows by the window they start in,
+	// e.g. PerMonth for monthly totals. Buckets longer than the period are
+	// counted in the period they start in. The zero value is PerMinute.
+	Period Window
+
+	// Resources names the resources to report on. When empty, Limiter.Report
+	// reports every registered resource and Report every resource found in
+	// the store.
+	Resources []string
+
+	// ByPartition reports each partition of a resource on its own row.
+	// Otherwise partitions are summed into one row per resource.
+	ByPartition bool
+}
+
+// ReportRow is a resource's usage in one report period.
+type ReportRow struct {
+	Resource  string
+	Partition string // empty unless ByPartition is set
+	Period    string // the period's bucket key, e.g. "2024-01"
+	Start     time.Time
+	Requests  int64
+	Spend     Money  // for resources with a Price
+	Currency  string // known to Limiter.Report only
+}
+
+// Report returns the usage of every registered resource, or those named in
+// opts, from the limiter's store. See the package-level Report.
+func (l *Limiter) Report(ctx context.Context, opts ReportOptions) ([]ReportRow, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	currency := make(map[string]string, len(l.resources))
+	for _, r := range l.resources {
+		currency[r.Name] = r.Currency
+	}
+	if len(opts.Resources) == 0 {
+		for _, r := range l.resources {
+			opts.Resources = append(opts.Resources, r.Name)
+		}
+	} else {
+		for _, name := range opts.Resources {
+			if _, ok := l.lookup(name); !ok {
+				return nil, fmt.Errorf("erl: resource %q not found", name)
+			}
+		}
+	}
+
+	rows, err := Report(ctx, l.store, opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range rows {
+		rows[i].Currency = currency[rows[i].Resource]
+	}
+	return rows, nil
+}
+
+// Report aggregates the call and spend counters kept in s by resource,
+// partition and period. It reads past buckets with store.HistoryStore, so
+// the store must archive them (see Limiter.History), and finds partitions
+// with store.KeyLister when s implements it. Rows are sorted by resource,
+// partition and period; periods without usage are left out.
+//
+// Report needs no limiter, so it can run in a separate process from the
+// services that record usage, such as the erl command's report subcommand.
+func Report(ctx context.Context, s store.Store, opts ReportOptions) ([]ReportRow, error) {
+	h, ok := store.AsHistoryStore(s)
+	if !ok {
+		return nil, fmt.Errorf("erl: %T does not keep history", s)
+	}
+	lister, _ := store.AsKeyLister(s)
+
+	names := opts.Resources
+	if len(names) == 0 {
+		if lister == nil {
+			return nil, fmt.Errorf("erl: %T cannot list keys; name the resources to report", s)
+		}
+		var err error
+		if names, err = reportResources(ctx, lister); err != nil {
+			return nil, fmt.Errorf("erl: report: %w", err)
+		}
+	}
+
+	type rowKey struct{ resource, partition, period string }
+	rows := make(map[rowKey]*ReportRow)
+	add := func(name, partition string, buckets []store.Bucket, spend bool) {
+		if !opts.ByPartition {
+			partition = ""
+		}
+		for _, b := range buckets {
+			if b.Count == 0 {
+				continue
+			}
+			k := rowKey{name, partition, opts.Period.BucketKey(b.Start)}
+			row := rows[k]
+			if row == nil {
+				row = &ReportRow{
+					Resource:  name,
+					Partition: partition,
+					Period:    k.period,
+					Start:     opts.Period.BucketStart(b.Start),
+				}
+				rows[k] = row
+			}
+			if spend {
+				row.Spend += Money(b.Count)
+			} else {
+				row.Requests += b.Count
+			}
+		}
+	}
+
+	for _, name := range names {
+		partitions := []string{""}
+		if lister != nil {
+			prefix := counterKey(name, "") + ":"
+			keys, err := lister.Keys(ctx, prefix)
+			if err != nil {
+				return nil, fmt.Errorf("erl: report %s: %w", name, err)
+			}
+			for _, key := range keys {
+				// Keys with a # suffix are the limiter's own spend, shadow
+				// and breaker counters.
+				if p := key[len(prefix):]; !strings.Contains(p, "#") {
+					partitions = append(partitions, keyUnescaper.Replace(p))
+				}
+			}
+		}
+
+		for _, p := range partitions {
+			key := counterKey(name, p)
+			calls, err := h.History(ctx, key, opts.From, opts.To)
+			if err != nil {
+				return nil, fmt.Errorf("erl: report %s: %w", name, err)
+			}
+			spent, err := h.History(ctx, spendKey(key), opts.From, opts.To)
+			if err != nil {
+				return nil, fmt.Errorf("erl: report %s: %w", name, err)
+			}
+			add(name, p, calls, false)
+			add(name, p, spent, true)
+		}
+	}
+
+	out := make([]ReportRow, 0, len(rows))
+	for _, row := range rows {
+		out = append(out, *row)
+	}
+	slices.SortFunc(out, func(a, b ReportRow) int {
+		return cmp.Or(
+			cmp.Compare(a.Resource, b.Resource),
+			cmp.Compare(a.Partition, b.Partition),
+			a.Start.Compare(b.Start),
+		)
+	})
+	return out, nil
+}
+
+// reportResources returns the names of the resources with counters in the
+// store: every key up to its first partition or suffix separator, unescaped.
+func reportResources(ctx context.Context, lister store.KeyLister) ([]string, error) {
+	keys, err := lister.Keys(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, key := range keys {
+		if i := strings.IndexAny(key, ":#"); i >= 0 {
+			key = key[:i]
+		}
+		names = append(names, keyUnescaper.Replace(key))
+	}
+	slices.Sort(names)
+	return slices.Compact(names), nil
+}
+
+// reportHeader is the CSV header written by WriteReportCSV.
+var reportHeader = []string{"resource", "partition", "period", "start", "requests", "spend", "currency"}
+
+// WriteReportCSV writes rows as CSV with a header line. Start is written in
+// RFC 3339 and Spend as a decimal amount with six places.
+func WriteReportCSV(w io.Writer, rows []ReportRow) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(reportHeader); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		if err := cw.Write([]string{
+			r.Resource,
+			r.Partition,
+			r.Period,
+			r.Start.UTC().Format(time.RFC3339),
+			strconv.FormatInt(r.Requests, 10),
+			r.Spend.String(),
+			r.Currency,
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// reportJSONRow is the JSON form of a ReportRow.
+type reportJSONRow struct {
+	Resource  string      `json:"resource"`
+	Partition string      `json:"partition,omitempty"`
+	Period    string      `json:"period"`
+	Start     time.Time   `json:"start"`
+	Requests  int64       `json:"requests"`
+	Spend     json.Number `json:"spend"`
+	Currency  string      `json:"currency,omitempty"`
+}
+
+// WriteReportJSON writes rows as a JSON array. Spend is written as a decimal
+// number with six places, so amounts are exact.
+func WriteReportJSON(w io.Writer, rows []ReportRow) error {
+	out := make([]reportJSONRow, len(rows))
+	for i, r := range rows {
+		out[i] = reportJSONRow{
+			Resource:  r.Resource,
+			Partition: r.Partition,
+			Period:    r.Period,
+			Start:     r.Start.UTC(),
+			Requests:  r.Requests,
+			Spend:     json.Number(r.Spend.String()),
+			Currency:  r.Currency,
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
@@ -0,0 +1,203 @@
+package erl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ryhazerus/erl/store"
+)
+
+// newReportStore returns a store holding daily usage of openai, partitioned
+// by tenant with spend, and stripe, across January and February 2024.
+func newReportStore(t *testing.T) *store.SQLiteStore {
+	t.Helper()
+	s, err := store.NewSQLiteStore(":memory:", store.WithHistory(100*365*24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	day := func(d int) store.Window {
+		return PerDay.storeWindow(time.Date(2024, 1, d, 12, 0, 0, 0, time.UTC))
+	}
+	ctx := context.Background()
+	for _, inc := range []struct {
+		key   string
+		day   int
+		delta int64
+	}{
+		{"openai:acme", 30, 3},
+		{"openai:acme#spend", 30, int64(Units(0.03))},
+		{"openai:acme", 31, 2},
+		{"openai:acme#spend", 31, int64(Units(0.02))},
+		{"openai:acme", 32, 4}, // February 1st
+		{"openai:acme#spend", 32, int64(Units(0.04))},
+		{"openai:globex", 31, 1},
+		{"openai:globex#spend", 31, int64(Units(0.01))},
+		{"openai:globex#shadow", 31, 9},
+		{"stripe", 15, 7},
+	} {
+		if _, err := s.IncrementMany(ctx, []store.Increment{{Key: inc.key, Window: day(inc.day), Delta: inc.delta}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestLimiterReport(t *testing.T) {
+	l := New(WithStore(newReportStore(t)))
+	l.Register(Resource{Name: "openai", Pattern: "api.openai.com/*", Window: PerDay, Price: Units(0.01), Currency: "USD"})
+	l.Register(Resource{Name: "stripe", Pattern: "api.stripe.com/*", Window: PerDay})
+
+	opts := ReportOptions{
+		From:        time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		To:          time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		Period:      PerMonth,
+		ByPartition: true,
+	}
+	got, err := l.Report(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ReportRow{
+		{"openai", "acme", "2024-01", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), 5, Units(0.05), "USD"},
+		{"openai", "acme", "2024-02", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), 4, Units(0.04), "USD"},
+		{"openai", "globex", "2024-01", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), 1, Units(0.01), "USD"},
+		{"stripe", "", "2024-01", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), 7, 0, ""},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("rows = %+v, want %d rows", got, len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("row %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	// Without ByPartition, tenants are summed per vendor.
+	opts.ByPartition = false
+	got, err = l.Report(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[0].Requests != 6 || got[0].Spend != Units(0.06) || got[1].Requests != 4 || got[2].Resource != "stripe" {
+		t.Errorf("totals = %+v", got)
+	}
+
+	// The range applies to bucket starts.
+	opts.From = time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	opts.To = time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	opts.Resources = []string{"openai"}
+	got, _ = l.Report(context.Background(), opts)
+	if len(got) != 1 || got[0].Requests != 3 {
+		t.Errorf("January 31st = %+v, want 3 requests", got)
+	}
+
+	opts.Resources = []string{"missing"}
+	if _, err := l.Report(context.Background(), opts); err == nil {
+		t.Error("expected an error for an unknown resource")
+	}
+}
+
+func TestReportDiscoversResources(t *testing.T) {
+	got, err := Report(context.Background(), newReportStore(t), ReportOptions{
+		From:   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		To:     time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		Period: PerMonth,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[0].Resource != "openai" || got[2].Resource != "stripe" {
+		t.Errorf("rows = %+v", got)
+	}
+
+	if _, err := Report(context.Background(), store.NewMemoryStore(), ReportOptions{}); err == nil {
+		t.Error("expected an error from a store without history")
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	rows := []ReportRow{
+		{"openai", "acme", "2024-01", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), 5, Units(0.05), "USD"},
+		{"stripe", "", "2024-01", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), 7, 0, ""},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteReportCSV(&buf, rows); err != nil {
+		t.Fatal(err)
+	}
+	want := "resource,partition,period,start,requests,spend,currency\n" +
+		"openai,acme,2024-01,2024-01-01T00:00:00Z,5,0.050000,USD\n" +
+		"stripe,,2024-01,2024-01-01T00:00:00Z,7,0.000000,\n"
+	if buf.String() != want {
+		t.Errorf("csv =\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := WriteReportJSON(&buf, rows); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 2 || decoded[0]["spend"] != 0.05 || decoded[0]["start"] != "2024-01-01T00:00:00Z" {
+		t.Errorf("json = %s", buf.String())
+	}
+	if _, ok := decoded[1]["partition"]; ok {
+		t.Errorf("empty partition written: %s", buf.String())
+	}
+}
+
+func TestReportResourceNamesWithColons(t *testing.T) {
+	s, err := store.NewSQLiteStore(":memory:", store.WithHistory(100*365*24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	w := PerDay.storeWindow(time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC))
+	ctx := context.Background()
+	for key, delta := range map[string]int64{
+		counterKey("openai", ""):           2,
+		counterKey("openai", "acme"):       3,
+		counterKey("openai:chat", ""):      5,
+		counterKey("openai:chat", "a:b"):   7,
+		spendKey(counterKey("openai", "")): int64(Units(0.02)),
+	} {
+		if _, err := s.IncrementMany(ctx, []store.Increment{{Key: key, Window: w, Delta: delta}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := Report(ctx, s, ReportOptions{
+		From:        time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		To:          time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+		Period:      PerMonth,
+		ByPartition: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		resource, partition string
+		requests            int64
+	}{
+		{"openai", "", 2},
+		{"openai", "acme", 3},
+		{"openai:chat", "", 5},
+		{"openai:chat", "a:b", 7},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("rows = %+v, want %d rows", got, len(want))
+	}
+	for i, w := range want {
+		if got[i].Resource != w.resource || got[i].Partition != w.partition || got[i].Requests != w.requests {
+			t.Errorf("row %d = %+v, want %+v", i, got[i], w)
+		}
+	}
+}
@@ -1,10 +1,69 @@
 package erl
 
+import (
+	"net/http"
+	"regexp"
+)
+
 // Resource defines a tracked external API endpoint with its rate limit configuration.
 type Resource struct {
-	Name     string   // unique identifier, e.g. "stripe-api"
-	Pattern  string   // URL match pattern, e.g. "api.stripe.com/*"
-	Limit    int64    // max calls allowed in the window
-	Window   Window   // PerMinute, PerHour, PerDay, PerMonth
-	Strategy Strategy // Block, BlockWithQueue, LogOnly
+	Name     string         // unique identifier, e.g. "stripe-api"
+	Pattern  string         // URL match pattern, e.g. "api.stripe.com/*"
+	Regexp   *regexp.Regexp // optional; matched against host + path instead of Pattern
+	Priority int            // higher priority wins when several resources match
+	Limit    int64          // max calls allowed in the window
+	Window   Window         // PerMinute, PerHour, PerDay, PerMonth
+	Strategy Strategy       // Block, BlockWithQueue, LogOnly, Fallback
+
+	// Partition optionally splits the resource into independent counters,
+	// e.g. one per tenant. See PartitionFromContext and PartitionFromHeader.
+	Partition PartitionFunc
+
+	// Group names another registered resource whose budget this resource
+	// also draws from. Every request is charged to both counters in one
+	// store operation and is blocked if either is exhausted. A group
+	// resource may leave Pattern empty so it is never matched directly.
+	Group string
+
+	// Budget caps spend per Window, in micro-units of Currency. Each request
+	// is charged Price up front and whatever PriceFunc returns once the
+	// response arrives; quota groups with a Budget are charged the same
+	// amount. A zero Limit with a non-zero Budget leaves call counts
+	// unlimited.
+	Budget    Money
+	Currency  string                                             // for display, e.g. "USD"
+	Price     Money                                              // fixed price per request
+	PriceFunc func(req *http.Request, resp *http.Response) Money // price computed from the exchange
+
+	// Thresholds are fractions of Limit (and of Budget, if set) at which
+	// the OnThreshold callback fires, e.g. []float64{0.5, 0.8, 0.95}. Each
+	// fires at most once per window, across all instances sharing a store
+	// that implements store.Marker.
+	Thresholds []float64
+
+	// Shadow is a proposed limit evaluated alongside the live one. Requests
+	// it would block are recorded and reported but never refused.
+	Shadow *Shadow
+
+	// Fallback serves requests in place of the upstream call when the
+	// Fallback strategy blocks them, e.g. a *LastResponse cache.
+	Fallback http.RoundTripper
+
+	// Cache enables an RFC 9111 response cache for the resource in
+	// Limiter.Transport. Cache hits are not charged against the limit.
+	Cache *CachePolicy
+
+	// Coalesce makes Limiter.Transport share one upstream call, charged
+	// once, between identical concurrent GET and HEAD requests. Every
+	// caller receives its own copy of the response.
+	Coalesce bool
+
+	// Breaker enables a circuit breaker in Limiter.Transport that stops
+	// sending requests to the upstream while it is failing.
+	Breaker *BreakerPolicy
+}
+
+// countLimited reports whether the resource enforces its call Limit.
+func (r Resource) countLimited() bool {
+	return r.Limit > 0 || r.Budget == 0
 }
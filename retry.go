@@ -0,0 +1,161 @@
+package erl
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy configures Limiter.RetryTransport.
+type RetryPolicy struct {
+	MaxAttempts int           // attempts including the first; default 3
+	BaseDelay   time.Duration // initial backoff; default 100 milliseconds
+	MaxDelay    time.Duration // cap on backoff and longest honored Retry-After; default 30 seconds
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 3
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = 100 * time.Millisecond
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = 30 * time.Second
+	}
+	return p
+}
+
+// RetryTransport wraps base like Transport and additionally retries
+// idempotent requests that fail with a transport error, 429 or 5xx. Retries
+// wait for the response's Retry-After or a jittered exponential backoff, and
+// every attempt is charged against the matching resource.
+//
+// Retrying stops early, returning the last response or error, when the
+// resource or a quota group above it would refuse the next attempt, when the
+// server's Retry-After is longer than MaxDelay, or when the request's context
+// deadline would pass before the next attempt. Requests refused by the
+// limiter itself are never retried.
+func (l *Limiter) RetryTransport(base http.RoundTripper, p RetryPolicy) http.RoundTripper {
+	return &retryTransport{
+		limiter: l,
+		next:    l.Transport(base),
+		policy:  p.withDefaults(),
+	}
+}
+
+type retryTransport struct {
+	limiter *Limiter
+	next    http.RoundTripper
+	policy  RetryPolicy
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	retryable := idempotent(req) && (req.Body == nil || req.Body == http.NoBody || req.GetBody != nil)
+
+	for attempt := 1; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if !retryable || attempt >= t.policy.MaxAttempts || !shouldRetry(resp, err) {
+			return resp, err
+		}
+
+		delay, ok := t.delay(attempt, resp)
+		if !ok {
+			return resp, err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return resp, err
+		}
+		if exhausted, herr := t.limiter.exhausted(ctx, req); herr != nil || exhausted {
+			return resp, err
+		}
+
+		next, rerr := rewind(req)
+		if rerr != nil {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		req = next
+	}
+}
+
+// delay returns how long to wait before the attempt after the given one. It
+// returns false when the server asked for a longer wait than MaxDelay, since
+// retrying sooner would ignore it.
+func (t *retryTransport) delay(attempt int, resp *http.Response) (time.Duration, bool) {
+	if resp != nil {
+		if d, ok := retryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			return d, d <= t.policy.MaxDelay
+		}
+	}
+	// Full jitter: a random delay up to the exponential backoff.
+	backoff := t.policy.BaseDelay << (attempt - 1)
+	if backoff <= 0 || backoff > t.policy.MaxDelay {
+		backoff = t.policy.MaxDelay
+	}
+	return rand.N(backoff + 1), true
+}
+
+// idempotent reports whether req may safely be sent more than once.
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+// shouldRetry reports whether an attempt's outcome is worth retrying.
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		var limErr *LimitExceededError
+		if errors.As(err, &limErr) || errors.Is(err, ErrCircuitOpen) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return false
+		}
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// retryAfter parses a Retry-After header given as seconds or an HTTP date.
+func retryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return max(0, time.Duration(secs)*time.Second), true
+	}
+	if at, err := http.ParseTime(v); err == nil {
+		return max(0, at.Sub(now)), true
+	}
+	return 0, false
+}
+
+// rewind returns a copy of req with a fresh body for another attempt.
+func rewind(req *http.Request) (*http.Request, error) {
+	next := req.Clone(req.Context())
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		next.Body = body
+	}
+	return next, nil
+}
@@ -0,0 +1,187 @@
+package erl
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newRetryTestServer(t *testing.T, failures int64, retryAfter string) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	hits := new(atomic.Int64)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) <= failures {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, hits
+}
+
+func TestRetryTransport(t *testing.T) {
+	srv, hits := newRetryTestServer(t, 2, "0")
+
+	l := New()
+	l.Register(Resource{Name: "retry-api", Pattern: "*", Limit: 10, Window: PerMinute, Strategy: Block})
+	client := &http.Client{Transport: l.RetryTransport(nil, RetryPolicy{MaxAttempts: 5})}
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200", resp.StatusCode)
+	}
+	if hits.Load() != 3 {
+		t.Errorf("upstream hits = %d, want 3", hits.Load())
+	}
+	// Every attempt is charged.
+	if usage, _ := l.GetUsage(context.Background(), "retry-api"); usage != 3 {
+		t.Errorf("usage = %d, want 3", usage)
+	}
+}
+
+func TestRetryTransportStopsWhenQuotaExhausted(t *testing.T) {
+	srv, hits := newRetryTestServer(t, 100, "0")
+
+	l := New()
+	l.Register(Resource{Name: "tight-api", Pattern: "*", Limit: 2, Window: PerMinute, Strategy: Block})
+	client := &http.Client{Transport: l.RetryTransport(nil, RetryPolicy{MaxAttempts: 5})}
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("expected the last response, got error %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503", resp.StatusCode)
+	}
+	if hits.Load() != 2 {
+		t.Errorf("upstream hits = %d, want 2", hits.Load())
+	}
+}
+
+func TestRetryTransportHeadroomStrategies(t *testing.T) {
+	tests := []struct {
+		name     string
+		resource Resource
+		want     int64
+	}{
+		// A LogOnly resource never refuses calls, so its limit does not
+		// stop retries.
+		{"log only", Resource{Limit: 1, Window: PerMinute, Strategy: LogOnly}, 4},
+		// A budget-only resource stops once the next attempt would overspend.
+		{"budget", Resource{Window: PerMinute, Strategy: Block, Budget: Units(0.02), Price: Units(0.01)}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, hits := newRetryTestServer(t, 100, "0")
+			l := New()
+			tt.resource.Name, tt.resource.Pattern = "api", "*"
+			l.Register(tt.resource)
+			client := &http.Client{Transport: l.RetryTransport(nil, RetryPolicy{MaxAttempts: 4})}
+
+			resp, err := client.Get(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if hits.Load() != tt.want {
+				t.Errorf("upstream hits = %d, want %d", hits.Load(), tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryTransportHonorsLongRetryAfter(t *testing.T) {
+	srv, hits := newRetryTestServer(t, 100, "60")
+
+	l := New()
+	client := &http.Client{Transport: l.RetryTransport(nil, RetryPolicy{MaxAttempts: 5, MaxDelay: time.Second})}
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable || hits.Load() != 1 {
+		t.Errorf("status = %d after %d hits; want the first 503 back", resp.StatusCode, hits.Load())
+	}
+}
+
+func TestRetryTransportRespectsDeadline(t *testing.T) {
+	srv, hits := newRetryTestServer(t, 100, "10")
+
+	l := New()
+	client := &http.Client{Transport: l.RetryTransport(nil, RetryPolicy{MaxAttempts: 5})}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("gave up after %v; want immediately", elapsed)
+	}
+	if hits.Load() != 1 {
+		t.Errorf("upstream hits = %d, want 1", hits.Load())
+	}
+}
+
+func TestRetryTransportSkipsNonIdempotent(t *testing.T) {
+	srv, hits := newRetryTestServer(t, 1, "0")
+
+	l := New()
+	client := &http.Client{Transport: l.RetryTransport(nil, RetryPolicy{})}
+
+	resp, err := client.Post(srv.URL, "text/plain", strings.NewReader("charge"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if hits.Load() != 1 {
+		t.Errorf("upstream hits = %d, want 1", hits.Load())
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{now.Add(30 * time.Second).Format(http.TimeFormat), 30 * time.Second, true},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0, true},
+		{"soon", 0, false},
+		{"", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := retryAfter(tt.in, now)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("retryAfter(%q) = %v, %v; want %v, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
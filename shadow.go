@@ -0,0 +1,66 @@
+package erl
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ryhazerus/erl/store"
+)
+
+// Shadow is a proposed limit evaluated alongside a resource's live limit
+// before it is enforced, e.g. while moving a resource from LogOnly to Block
+// or tightening its window.
+type Shadow struct {
+	Limit  int64  // proposed max calls in the window
+	Window Window // proposed window
+}
+
+// shadowKey returns the store key of the shadow counter for a counter key.
+func shadowKey(key string) string {
+	return key + "#shadow"
+}
+
+// deniedKey returns the store key counting shadow denials for a counter key.
+func deniedKey(key string) string {
+	return key + "#shadow-denied"
+}
+
+// recordShadowDenials counts the would-be denials of a check and fires the
+// OnShadowDenied callback for each.
+func (l *Limiter) recordShadowDenials(ctx context.Context, denied []*charge) error {
+	if len(denied) == 0 {
+		return nil
+	}
+
+	incs := make([]store.Increment, len(denied))
+	for i, c := range denied {
+		incs[i] = store.Increment{Key: deniedKey(c.key()), Window: c.shadowWindow, Delta: 1}
+	}
+	if _, err := l.incrementAll(ctx, incs); err != nil {
+		return fmt.Errorf("erl: store error: %w", err)
+	}
+
+	if l.onShadowDenied != nil {
+		for _, c := range denied {
+			l.onShadowDenied(c.resource, c.shadowCurrent)
+		}
+	}
+	return nil
+}
+
+// snapshotShadow queues the reads of st's shadow counters with get. In a
+// dry run the shadow counter is the live one, which Snapshot copies over once
+// it has been read.
+func (l *Limiter) snapshotShadow(st *ResourceStatus, now time.Time, get func(key string, w store.Window, dst *int64)) {
+	r := st.Resource
+	switch {
+	case r.Shadow != nil:
+		w := r.Shadow.Window.storeWindow(now)
+		key := counterKey(r.Name, "")
+		get(shadowKey(key), w, &st.ShadowCurrent)
+		get(deniedKey(key), w, &st.ShadowDenied)
+	case l.dryRun:
+		get(deniedKey(counterKey(r.Name, "")), r.Window.storeWindow(now), &st.ShadowDenied)
+	}
+}
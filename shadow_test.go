@@ -0,0 +1,78 @@
+package erl
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLimiterShadow(t *testing.T) {
+	var denials []int64
+	l := New(WithOnShadowDenied(func(r Resource, current int64) {
+		denials = append(denials, current)
+	}))
+	l.Register(Resource{
+		Name:     "shadow-api",
+		Pattern:  "api.shadow.com/*",
+		Limit:    100,
+		Window:   PerMinute,
+		Strategy: LogOnly,
+		Shadow:   &Shadow{Limit: 3, Window: PerHour},
+	})
+
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		if err := l.Check(ctx, "https://api.shadow.com/v1"); err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+	}
+
+	if len(denials) != 2 || denials[0] != 4 || denials[1] != 5 {
+		t.Errorf("denials = %v, want [4 5]", denials)
+	}
+
+	statuses, err := l.Snapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := statuses[0]
+	if st.Current != 5 || st.ShadowCurrent != 5 || st.ShadowDenied != 2 {
+		t.Errorf("snapshot = %d/%d/%d, want 5/5/2", st.Current, st.ShadowCurrent, st.ShadowDenied)
+	}
+
+	if err := l.ResetUsage(ctx, "shadow-api"); err != nil {
+		t.Fatal(err)
+	}
+	statuses, _ = l.Snapshot(ctx)
+	st = statuses[0]
+	if st.Current != 0 || st.ShadowCurrent != 0 || st.ShadowDenied != 0 {
+		t.Errorf("after reset = %d/%d/%d, want 0/0/0", st.Current, st.ShadowCurrent, st.ShadowDenied)
+	}
+}
+
+func TestLimiterDryRun(t *testing.T) {
+	var denied int
+	l := New(WithDryRun(), WithOnShadowDenied(func(Resource, int64) { denied++ }))
+	l.Register(Resource{
+		Name:     "dry-api",
+		Pattern:  "api.dry.com/*",
+		Limit:    2,
+		Window:   PerMinute,
+		Strategy: Block,
+	})
+
+	ctx := context.Background()
+	for i := 0; i < 4; i++ {
+		if err := l.Check(ctx, "https://api.dry.com/v1"); err != nil {
+			t.Fatalf("request %d: dry run should not block, got %v", i+1, err)
+		}
+	}
+
+	if denied != 2 {
+		t.Errorf("denied = %d, want 2", denied)
+	}
+
+	statuses, _ := l.Snapshot(ctx)
+	if statuses[0].ShadowDenied != 2 {
+		t.Errorf("snapshot denied = %d, want 2", statuses[0].ShadowDenied)
+	}
+}
@@ -0,0 +1,308 @@
+// Package bolt provides a file-backed store for erl built on bbolt, for
+// single-node tools that need counters to survive restarts without a SQL
+// driver.
+package bolt
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ryhazerus/erl/store"
+	"go.etcd.io/bbolt"
+)
+
+// Compile-time interface checks.
+var (
+	_ store.Store       = (*BoltStore)(nil)
+	_ store.Batcher     = (*BoltStore)(nil)
+	_ store.Marker      = (*BoltStore)(nil)
+	_ store.BatchGetter = (*BoltStore)(nil)
+)
+
+// Top-level bbolt buckets. Each counter key has its own nested bucket under
+// countersBucket holding the fields below; marks are plain entries.
+var (
+	countersBucket = []byte("erl_counters")
+	marksBucket    = []byte("erl_marks")
+
+	fieldCount     = []byte("count")
+	fieldBucketKey = []byte("bucket_key")
+	fieldExpires   = []byte("expires")
+)
+
+// ErrEmptyKey is returned when a counter or mark is written with an empty
+// key, which bbolt cannot store.
+var ErrEmptyKey = errors.New("erl/store/bolt: empty key")
+
+// Option configures a BoltStore.
+type Option func(*BoltStore)
+
+// WithPruneInterval sets how often counters and marks whose window has ended
+// are deleted in the background. The default is ten minutes; zero disables
+// background pruning, leaving it to explicit Prune calls.
+func WithPruneInterval(d time.Duration) Option {
+	return func(s *BoltStore) {
+		s.interval = d
+	}
+}
+
+// BoltStore is a persistent Store backed by a bbolt database file. Every
+// update runs in a single bbolt write transaction, so increments are atomic
+// even when several goroutines share the store. bbolt locks the file, so a
+// database can only be used by one process at a time.
+type BoltStore struct {
+	db       *bbolt.DB
+	interval time.Duration
+
+	stop      chan struct{}
+	done      sync.WaitGroup
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewBoltStore opens (or creates) the bbolt database at path and starts
+// background pruning.
+func NewBoltStore(path string, opts ...Option) (*BoltStore, error) {
+	db, err := bbolt.Open(path, 0o600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("erl/store/bolt: open: %w", err)
+	}
+
+	if err := db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{countersBucket, marksBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("erl/store/bolt: create buckets: %w", err)
+	}
+
+	s := &BoltStore{db: db, interval: 10 * time.Minute, stop: make(chan struct{})}
+	for _, o := range opts {
+		o(s)
+	}
+	if s.interval > 0 {
+		s.done.Add(1)
+		go s.pruneLoop()
+	}
+	return s, nil
+}
+
+// Increment atomically adds one to the counter for key in the current window
+// bucket. If the window bucket has rolled over, the counter is reset first.
+func (s *BoltStore) Increment(ctx context.Context, key string, w store.Window) (int64, error) {
+	counts, err := s.IncrementMany(ctx, []store.Increment{{Key: key, Window: w, Delta: 1}})
+	if err != nil {
+		return 0, err
+	}
+	return counts[0], nil
+}
+
+// IncrementMany applies all increments in a single write transaction.
+func (s *BoltStore) IncrementMany(_ context.Context, incs []store.Increment) ([]int64, error) {
+	for _, inc := range incs {
+		if inc.Key == "" {
+			return nil, ErrEmptyKey
+		}
+	}
+	out := make([]int64, len(incs))
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		counters := tx.Bucket(countersBucket)
+		for i, inc := range incs {
+			b, err := counters.CreateBucketIfNotExists([]byte(inc.Key))
+			if err != nil {
+				return err
+			}
+
+			var count int64
+			if string(b.Get(fieldBucketKey)) == inc.Window.BucketKey {
+				count = decode(b.Get(fieldCount))
+			}
+			count += inc.Delta
+
+			if err := b.Put(fieldCount, encode(count)); err != nil {
+				return err
+			}
+			if err := b.Put(fieldBucketKey, []byte(inc.Window.BucketKey)); err != nil {
+				return err
+			}
+			if err := b.Put(fieldExpires, encode(expiry(inc.Window).UnixNano())); err != nil {
+				return err
+			}
+			out[i] = count
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("erl/store/bolt: increment: %w", err)
+	}
+	return out, nil
+}
+
+// Get returns the current counter value for key in the active window bucket.
+func (s *BoltStore) Get(ctx context.Context, key string, w store.Window) (int64, error) {
+	counts, err := s.GetMany(ctx, []store.Read{{Key: key, Window: w}})
+	if err != nil {
+		return 0, err
+	}
+	return counts[0], nil
+}
+
+// GetMany reads every counter in a single read transaction.
+func (s *BoltStore) GetMany(_ context.Context, reads []store.Read) ([]int64, error) {
+	out := make([]int64, len(reads))
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		counters := tx.Bucket(countersBucket)
+		for i, rd := range reads {
+			b := counters.Bucket([]byte(rd.Key))
+			if b != nil && string(b.Get(fieldBucketKey)) == rd.Window.BucketKey {
+				out[i] = decode(b.Get(fieldCount))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("erl/store/bolt: get: %w", err)
+	}
+	return out, nil
+}
+
+// Mark records key in the window bucket and reports whether it was new.
+func (s *BoltStore) Mark(_ context.Context, key string, w store.Window) (bool, error) {
+	if key == "" {
+		return false, ErrEmptyKey
+	}
+	var first bool
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		marks := tx.Bucket(marksBucket)
+		if v := marks.Get([]byte(key)); len(v) >= 8 && string(v[8:]) == w.BucketKey {
+			return nil
+		}
+		first = true
+		return marks.Put([]byte(key), append(encode(expiry(w).UnixNano()), w.BucketKey...))
+	})
+	if err != nil {
+		return false, fmt.Errorf("erl/store/bolt: mark: %w", err)
+	}
+	return first, nil
+}
+
+// Reset removes the counter for the given key.
+func (s *BoltStore) Reset(_ context.Context, key string) error {
+	if key == "" {
+		// No counter can be stored under an empty key.
+		return nil
+	}
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		err := tx.Bucket(countersBucket).DeleteBucket([]byte(key))
+		if err == bbolt.ErrBucketNotFound {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("erl/store/bolt: reset: %w", err)
+	}
+	return nil
+}
+
+// Prune deletes counters and marks whose window ended before now. Their
+// values would be discarded on the next increment anyway. bbolt reuses the
+// freed pages for later writes but never shrinks the file; use bbolt's
+// compact command offline to reclaim disk space.
+func (s *BoltStore) Prune(now time.Time) error {
+	cutoff := now.UnixNano()
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		counters := tx.Bucket(countersBucket)
+		var stale [][]byte
+		if err := counters.ForEachBucket(func(k []byte) error {
+			if decode(counters.Bucket(k).Get(fieldExpires)) < cutoff {
+				stale = append(stale, k)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, k := range stale {
+			if err := counters.DeleteBucket(k); err != nil {
+				return err
+			}
+		}
+
+		marks := tx.Bucket(marksBucket)
+		stale = stale[:0]
+		if err := marks.ForEach(func(k, v []byte) error {
+			if len(v) >= 8 && decode(v[:8]) < cutoff {
+				stale = append(stale, k)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, k := range stale {
+			if err := marks.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("erl/store/bolt: prune: %w", err)
+	}
+	return nil
+}
+
+// pruneLoop runs Prune every s.interval until the store is closed.
+func (s *BoltStore) pruneLoop() {
+	defer s.done.Done()
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			// A failed pass is retried on the next tick.
+			_ = s.Prune(now)
+		}
+	}
+}
+
+// Close stops background pruning and closes the database file. Calling it
+// more than once returns the result of the first call.
+func (s *BoltStore) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		s.done.Wait()
+		s.closeErr = s.db.Close()
+	})
+	return s.closeErr
+}
+
+// expiry returns when w's bucket ends. Windows without a start are assumed
+// to begin now.
+func expiry(w store.Window) time.Time {
+	start := w.BucketStart
+	if start.IsZero() {
+		start = time.Now()
+	}
+	return start.Add(w.Duration)
+}
+
+func encode(n int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(n))
+}
+
+func decode(b []byte) int64 {
+	if len(b) != 8 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(b))
+}
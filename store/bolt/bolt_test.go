@@ -0,0 +1,288 @@
+package bolt
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ryhazerus/erl/store"
+	"go.etcd.io/bbolt"
+)
+
+func newTestBoltStore(t *testing.T, opts ...Option) *BoltStore {
+	t.Helper()
+	s, err := NewBoltStore(filepath.Join(t.TempDir(), "erl.db"), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+var (
+	w1 = store.Window{
+		Duration:    time.Minute,
+		BucketKey:   "2024-01-15T14:30",
+		BucketStart: time.Date(2024, 1, 15, 14, 30, 0, 0, time.UTC),
+	}
+	w2 = store.Window{
+		Duration:    time.Minute,
+		BucketKey:   "2024-01-15T14:31",
+		BucketStart: time.Date(2024, 1, 15, 14, 31, 0, 0, time.UTC),
+	}
+)
+
+func TestBoltStoreIncrement(t *testing.T) {
+	s := newTestBoltStore(t)
+	ctx := context.Background()
+
+	for i := int64(1); i <= 5; i++ {
+		got, err := s.Increment(ctx, "test", w1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != i {
+			t.Errorf("increment %d: got %d, want %d", i, got, i)
+		}
+	}
+}
+
+func TestBoltStoreWindowRollover(t *testing.T) {
+	s := newTestBoltStore(t)
+	ctx := context.Background()
+
+	s.Increment(ctx, "key", w1)
+	s.Increment(ctx, "key", w1)
+
+	if got, _ := s.Increment(ctx, "key", w2); got != 1 {
+		t.Errorf("after rollover: got %d, want 1", got)
+	}
+	if got, _ := s.Get(ctx, "key", w1); got != 0 {
+		t.Errorf("old bucket: got %d, want 0", got)
+	}
+}
+
+func TestBoltStoreGetAndReset(t *testing.T) {
+	s := newTestBoltStore(t)
+	ctx := context.Background()
+
+	if got, _ := s.Get(ctx, "key", w1); got != 0 {
+		t.Errorf("initial get: got %d, want 0", got)
+	}
+	s.Increment(ctx, "key", w1)
+	s.Increment(ctx, "key", w1)
+	if got, _ := s.Get(ctx, "key", w1); got != 2 {
+		t.Errorf("get: got %d, want 2", got)
+	}
+
+	if err := s.Reset(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reset(ctx, "missing"); err != nil {
+		t.Errorf("reset of unknown key: %v", err)
+	}
+	if got, _ := s.Get(ctx, "key", w1); got != 0 {
+		t.Errorf("after reset: got %d, want 0", got)
+	}
+}
+
+func TestBoltStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "erl.db")
+	ctx := context.Background()
+
+	s, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Increment(ctx, "key", w1)
+	s.Increment(ctx, "key", w1)
+	s.Close()
+
+	s, err = NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got, _ := s.Get(ctx, "key", w1); got != 2 {
+		t.Errorf("after reopen: got %d, want 2", got)
+	}
+}
+
+func TestBoltStoreConcurrentIncrements(t *testing.T) {
+	s := newTestBoltStore(t)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Increment(ctx, "shared", w1); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got, _ := s.Get(ctx, "shared", w1); got != 50 {
+		t.Errorf("got %d, want 50", got)
+	}
+}
+
+func TestBoltStoreIncrementMany(t *testing.T) {
+	s := newTestBoltStore(t)
+	ctx := context.Background()
+
+	s.Increment(ctx, "group", w1)
+	got, err := s.IncrementMany(ctx, []store.Increment{
+		{Key: "child", Window: w1, Delta: 1},
+		{Key: "group", Window: w1, Delta: 1},
+		{Key: "child#spend", Window: w1, Delta: 2500},
+		{Key: "peek", Window: w1, Delta: 0},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int64{1, 2, 2500, 0}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("count %d: got %d, want %d", i, got[i], want[i])
+		}
+	}
+}
+
+func TestBoltStoreMark(t *testing.T) {
+	s := newTestBoltStore(t)
+	ctx := context.Background()
+
+	for i, tc := range []struct {
+		w    store.Window
+		want bool
+	}{{w1, true}, {w1, false}, {w2, true}, {w2, false}} {
+		got, err := s.Mark(ctx, "warn", tc.w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("mark %d: got %v, want %v", i+1, got, tc.want)
+		}
+	}
+}
+
+func TestBoltStorePrune(t *testing.T) {
+	s := newTestBoltStore(t, WithPruneInterval(0))
+	ctx := context.Background()
+
+	s.Increment(ctx, "old", w1)
+	s.Increment(ctx, "current", w2)
+	s.Mark(ctx, "old-mark", w1)
+	s.Mark(ctx, "current-mark", w2)
+
+	// w1 has ended and w2 is still open.
+	if err := s.Prune(w2.BucketStart.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := countEntries(t, s, countersBucket); got != 1 {
+		t.Errorf("counters after pruning = %d, want 1", got)
+	}
+	if got := countEntries(t, s, marksBucket); got != 1 {
+		t.Errorf("marks after pruning = %d, want 1", got)
+	}
+	if got, _ := s.Get(ctx, "current", w2); got != 1 {
+		t.Errorf("current counter = %d, want 1", got)
+	}
+	// The current mark survives pruning.
+	if first, _ := s.Mark(ctx, "current-mark", w2); first {
+		t.Error("current mark was removed")
+	}
+}
+
+func TestBoltStoreBackgroundPruning(t *testing.T) {
+	s := newTestBoltStore(t, WithPruneInterval(10*time.Millisecond))
+	ctx := context.Background()
+
+	// A window that ended long ago.
+	s.Increment(ctx, "old", w1)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for countEntries(t, s, countersBucket) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expired counter was not pruned")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func countEntries(t *testing.T, s *BoltStore, bucket []byte) int {
+	t.Helper()
+	var n int
+	if err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(_, _ []byte) error {
+			n++
+			return nil
+		})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestBoltStoreGetMany(t *testing.T) {
+	s := newTestBoltStore(t)
+	ctx := context.Background()
+
+	s.Increment(ctx, "stripe", w1)
+	s.Increment(ctx, "stripe", w1)
+	s.IncrementMany(ctx, []store.Increment{{Key: "stripe#spend", Window: w1, Delta: 1500}})
+
+	got, err := s.GetMany(ctx, []store.Read{
+		{Key: "stripe", Window: w1},
+		{Key: "stripe#spend", Window: w1},
+		{Key: "missing", Window: w1},
+		{Key: "stripe", Window: w2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int64{2, 1500, 0, 0}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("count %d: got %d, want %d", i, got[i], want[i])
+		}
+	}
+}
+
+func TestBoltStoreEmptyKey(t *testing.T) {
+	s := newTestBoltStore(t)
+	ctx := context.Background()
+
+	if _, err := s.Increment(ctx, "", w1); !errors.Is(err, ErrEmptyKey) {
+		t.Errorf("Increment error = %v, want ErrEmptyKey", err)
+	}
+	if _, err := s.Mark(ctx, "", w1); !errors.Is(err, ErrEmptyKey) {
+		t.Errorf("Mark error = %v, want ErrEmptyKey", err)
+	}
+	if got, err := s.Get(ctx, "", w1); err != nil || got != 0 {
+		t.Errorf("Get = %d, %v, want 0, nil", got, err)
+	}
+	if err := s.Reset(ctx, ""); err != nil {
+		t.Errorf("Reset error = %v", err)
+	}
+}
+
+func TestBoltStoreCloseTwice(t *testing.T) {
+	s, err := NewBoltStore(filepath.Join(t.TempDir(), "erl.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("second Close = %v, want nil", err)
+	}
+}
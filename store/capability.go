@@ -0,0 +1,56 @@
+package store
+
+// Capability names one of the optional store interfaces.
+type Capability int
+
+const (
+	CapBatcher      Capability = iota + 1 // Batcher
+	CapHistoryStore                       // HistoryStore
+	CapKeyLister                          // KeyLister
+)
+
+// CapabilityChecker is implemented by stores that have the methods of an
+// optional interface but can only honour it some of the time, such as a
+// TieredStore over a backend without that interface. Use AsBatcher,
+// AsHistoryStore and AsKeyLister rather than plain type assertions so such
+// stores are treated as what they support.
+type CapabilityChecker interface {
+	// Supports reports whether the store supports the interface c names.
+	Supports(c Capability) bool
+}
+
+// supports reports whether s supports c, assuming it does unless s is a
+// CapabilityChecker.
+func supports(s Store, c Capability) bool {
+	cc, ok := s.(CapabilityChecker)
+	return !ok || cc.Supports(c)
+}
+
+// AsBatcher returns s as a Batcher if it implements and supports Batcher.
+func AsBatcher(s Store) (Batcher, bool) {
+	b, ok := s.(Batcher)
+	if !ok || !supports(s, CapBatcher) {
+		return nil, false
+	}
+	return b, true
+}
+
+// AsHistoryStore returns s as a HistoryStore if it implements and supports
+// HistoryStore.
+func AsHistoryStore(s Store) (HistoryStore, bool) {
+	h, ok := s.(HistoryStore)
+	if !ok || !supports(s, CapHistoryStore) {
+		return nil, false
+	}
+	return h, true
+}
+
+// AsKeyLister returns s as a KeyLister if it implements and supports
+// KeyLister.
+func AsKeyLister(s Store) (KeyLister, bool) {
+	k, ok := s.(KeyLister)
+	if !ok || !supports(s, CapKeyLister) {
+		return nil, false
+	}
+	return k, true
+}
@@ -4,5 +4,14 @@
 //   - [MemoryStore]: fast, in-memory counters that are lost on restart.
 //   - [SQLiteStore]: persistent counters backed by a SQLite database.
 //
+// Other backends (Redis, PostgreSQL, MySQL, bbolt, memcached and NATS) live
+// in their own modules under store/ so the core stays light.
+//
 // Custom backends can be created by implementing the [Store] interface.
+// Backends may additionally implement optional interfaces such as [Batcher]
+// to support atomic multi-counter updates, or [Adder] for weighted updates
+// applied one counter at a time. Stores that wrap another store, such as
+// [TieredStore], implement [CapabilityChecker] to report which of those
+// interfaces they actually support; check them with [AsBatcher],
+// [AsHistoryStore] and [AsKeyLister].
 package store
@@ -0,0 +1,32 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Bucket is the count a counter reached in one window bucket.
+type Bucket struct {
+	Key   string // the window's BucketKey
+	Start time.Time
+	End   time.Time
+	Count int64
+}
+
+// HistoryStore is implemented by stores that can archive the final count of
+// each window bucket when a counter rolls over, so usage can be queried after
+// the window has passed. Archiving is opt-in; see each store's options.
+type HistoryStore interface {
+	// History returns the buckets of key that started in [from, to), oldest
+	// first: the archived buckets and the one the counter currently holds.
+	// Without archiving enabled only the current bucket is returned.
+	History(ctx context.Context, key string, from, to time.Time) ([]Bucket, error)
+}
+
+// KeyLister is implemented by stores that can list the keys they hold
+// counters or archived buckets for. Usage reports use it to find every
+// partition of a resource.
+type KeyLister interface {
+	// Keys returns the keys starting with prefix, in no particular order.
+	Keys(ctx context.Context, prefix string) ([]string, error)
+}
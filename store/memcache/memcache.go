@@ -0,0 +1,234 @@
+// Package memcache provides a memcached-backed store for erl.
+package memcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/ryhazerus/erl/store"
+)
+
+// Compile-time interface checks.
+var (
+	_ store.Store       = (*MemcacheStore)(nil)
+	_ store.Adder       = (*MemcacheStore)(nil)
+	_ store.Marker      = (*MemcacheStore)(nil)
+	_ store.BatchGetter = (*MemcacheStore)(nil)
+)
+
+// MemcacheStore is a Store backed by memcached. Each window bucket has its
+// own item, "erl:<key>:<bucket>", holding a decimal counter that expires with
+// the window, so rollover needs no reset: a new bucket is simply a new item.
+// Counters are updated with memcached's atomic incr.
+//
+// memcached has no multi-key transactions, so the store implements
+// store.Adder rather than store.Batcher: a resource and its quota groups are
+// updated one at a time, and if an update fails part way, earlier counters
+// stay incremented.
+type MemcacheStore struct {
+	client *memcache.Client
+}
+
+// NewMemcacheStore creates a new memcached-backed store.
+func NewMemcacheStore(client *memcache.Client) *MemcacheStore {
+	return &MemcacheStore{client: client}
+}
+
+// Increment atomically adds one to the counter for key in the current window
+// bucket.
+func (m *MemcacheStore) Increment(_ context.Context, key string, w store.Window) (int64, error) {
+	count, err := m.add(key, w, 1)
+	if err != nil {
+		return 0, fmt.Errorf("erl/store/memcache: increment: %w", err)
+	}
+	return count, nil
+}
+
+// Add adds delta to the counter for key in the current window bucket.
+func (m *MemcacheStore) Add(_ context.Context, key string, w store.Window, delta int64) (int64, error) {
+	count, err := m.add(key, w, delta)
+	if err != nil {
+		return 0, fmt.Errorf("erl/store/memcache: add: %w", err)
+	}
+	return count, nil
+}
+
+// add adds delta to the counter for key in w's bucket. The first increment of
+// a bucket creates the item with add, which fails if another client created
+// it first; incr is then retried against that client's item.
+func (m *MemcacheStore) add(key string, w store.Window, delta int64) (int64, error) {
+	if delta < 0 {
+		return 0, fmt.Errorf("negative delta %d", delta)
+	}
+	if delta == 0 {
+		return m.get(key, w)
+	}
+
+	item := itemKey(key, w.BucketKey)
+	for {
+		n, err := m.client.Increment(item, uint64(delta))
+		if err == nil {
+			return int64(n), nil
+		}
+		if !errors.Is(err, memcache.ErrCacheMiss) {
+			return 0, err
+		}
+
+		err = m.client.Add(&memcache.Item{
+			Key:        item,
+			Value:      []byte(strconv.FormatInt(delta, 10)),
+			Expiration: expiration(w),
+		})
+		if err == nil {
+			// Record the live bucket so Reset can find it.
+			if err := m.client.Set(&memcache.Item{
+				Key:        itemKey(key, ""),
+				Value:      []byte(w.BucketKey),
+				Expiration: expiration(w),
+			}); err != nil {
+				return 0, err
+			}
+			return delta, nil
+		}
+		if !errors.Is(err, memcache.ErrNotStored) {
+			return 0, err
+		}
+		// Lost the race to create the item; increment the winner's.
+	}
+}
+
+// Get returns the current counter value for key in the active window bucket.
+func (m *MemcacheStore) Get(_ context.Context, key string, w store.Window) (int64, error) {
+	count, err := m.get(key, w)
+	if err != nil {
+		return 0, fmt.Errorf("erl/store/memcache: get: %w", err)
+	}
+	return count, nil
+}
+
+func (m *MemcacheStore) get(key string, w store.Window) (int64, error) {
+	it, err := m.client.Get(itemKey(key, w.BucketKey))
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return parseCount(it.Value)
+}
+
+// parseCount parses a counter item. memcached may pad incremented values
+// with trailing spaces.
+func parseCount(value []byte) (int64, error) {
+	return strconv.ParseInt(strings.TrimSpace(string(value)), 10, 64)
+}
+
+// GetMany reads every counter with a single get of all their items.
+func (m *MemcacheStore) GetMany(_ context.Context, reads []store.Read) ([]int64, error) {
+	keys := make([]string, len(reads))
+	for i, rd := range reads {
+		keys[i] = itemKey(rd.Key, rd.Window.BucketKey)
+	}
+	items, err := m.client.GetMulti(keys)
+	if err != nil {
+		return nil, fmt.Errorf("erl/store/memcache: get many: %w", err)
+	}
+
+	out := make([]int64, len(reads))
+	for i, k := range keys {
+		it, ok := items[k]
+		if !ok {
+			continue
+		}
+		if out[i], err = parseCount(it.Value); err != nil {
+			return nil, fmt.Errorf("erl/store/memcache: get many: %w", err)
+		}
+	}
+	return out, nil
+}
+
+// Mark records key in the window bucket with add, which only succeeds for
+// the first caller, and reports whether this call created it.
+func (m *MemcacheStore) Mark(_ context.Context, key string, w store.Window) (bool, error) {
+	err := m.client.Add(&memcache.Item{
+		Key:        itemKey(key, w.BucketKey),
+		Value:      []byte("1"),
+		Expiration: expiration(w),
+	})
+	if errors.Is(err, memcache.ErrNotStored) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("erl/store/memcache: mark: %w", err)
+	}
+	return true, nil
+}
+
+// Reset removes the counter for the given key in the bucket it was last
+// incremented in.
+func (m *MemcacheStore) Reset(_ context.Context, key string) error {
+	it, err := m.client.Get(itemKey(key, ""))
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("erl/store/memcache: reset: %w", err)
+	}
+
+	for _, k := range []string{itemKey(key, string(it.Value)), itemKey(key, "")} {
+		if err := m.client.Delete(k); err != nil && !errors.Is(err, memcache.ErrCacheMiss) {
+			return fmt.Errorf("erl/store/memcache: reset: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close closes the client's idle connections.
+func (m *MemcacheStore) Close() error {
+	return m.client.Close()
+}
+
+// itemKey returns the memcached key for a counter's bucket, or for the
+// record of its live bucket when bucket is empty. memcached keys are limited
+// to 250 bytes without spaces or control characters; other keys are hashed.
+func itemKey(key, bucket string) string {
+	k := "erl:" + key + ":" + bucket
+	if legalKey(k) {
+		return k
+	}
+	sum := sha256.Sum256([]byte(key + ":" + bucket))
+	return "erl:sha256:" + hex.EncodeToString(sum[:])
+}
+
+func legalKey(k string) bool {
+	if len(k) > 250 {
+		return false
+	}
+	for i := 0; i < len(k); i++ {
+		if k[i] <= ' ' || k[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// maxRelativeExpiration is the longest expiration memcached treats as
+// relative; larger values are read as Unix timestamps.
+const maxRelativeExpiration = 30 * 24 * time.Hour
+
+// expiration returns the item expiration for w: the window duration rounded
+// up to whole seconds, or the bucket's end as a Unix timestamp for windows
+// longer than 30 days.
+func expiration(w store.Window) int32 {
+	if w.Duration <= maxRelativeExpiration {
+		return int32((w.Duration + time.Second - 1) / time.Second)
+	}
+	return int32(w.BucketStart.Add(w.Duration).Unix())
+}
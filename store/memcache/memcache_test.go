@@ -0,0 +1,211 @@
+package memcache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/daangn/minimemcached"
+	"github.com/ryhazerus/erl/store"
+)
+
+func newTestMemcacheStore(t *testing.T) *MemcacheStore {
+	t.Helper()
+	m, err := minimemcached.Run(&minimemcached.Config{Port: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(m.Close)
+
+	s := NewMemcacheStore(memcache.New(fmt.Sprintf("localhost:%d", m.Port())))
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+var (
+	w1 = store.Window{
+		Duration:    time.Minute,
+		BucketKey:   "2024-01-15T14:30",
+		BucketStart: time.Date(2024, 1, 15, 14, 30, 0, 0, time.UTC),
+	}
+	w2 = store.Window{
+		Duration:    time.Minute,
+		BucketKey:   "2024-01-15T14:31",
+		BucketStart: time.Date(2024, 1, 15, 14, 31, 0, 0, time.UTC),
+	}
+)
+
+func TestMemcacheStoreIncrement(t *testing.T) {
+	s := newTestMemcacheStore(t)
+	ctx := context.Background()
+
+	for i := int64(1); i <= 5; i++ {
+		got, err := s.Increment(ctx, "test", w1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != i {
+			t.Errorf("increment %d: got %d, want %d", i, got, i)
+		}
+	}
+}
+
+func TestMemcacheStoreWindowRollover(t *testing.T) {
+	s := newTestMemcacheStore(t)
+	ctx := context.Background()
+
+	s.Increment(ctx, "key", w1)
+	s.Increment(ctx, "key", w1)
+
+	if got, _ := s.Increment(ctx, "key", w2); got != 1 {
+		t.Errorf("after rollover: got %d, want 1", got)
+	}
+}
+
+func TestMemcacheStoreGetAndReset(t *testing.T) {
+	s := newTestMemcacheStore(t)
+	ctx := context.Background()
+
+	if got, err := s.Get(ctx, "key", w1); err != nil || got != 0 {
+		t.Fatalf("initial get: got %d, %v; want 0", got, err)
+	}
+	s.Increment(ctx, "key", w1)
+	s.Increment(ctx, "key", w1)
+	if got, _ := s.Get(ctx, "key", w1); got != 2 {
+		t.Errorf("get: got %d, want 2", got)
+	}
+
+	if err := s.Reset(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reset(ctx, "missing"); err != nil {
+		t.Errorf("reset of unknown key: %v", err)
+	}
+	if got, _ := s.Get(ctx, "key", w1); got != 0 {
+		t.Errorf("after reset: got %d, want 0", got)
+	}
+}
+
+func TestMemcacheStoreConcurrentFirstIncrement(t *testing.T) {
+	s := newTestMemcacheStore(t)
+	ctx := context.Background()
+
+	// Every goroutine may find the item missing and race to add it.
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Increment(ctx, "shared", w1); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got, _ := s.Get(ctx, "shared", w1); got != 20 {
+		t.Errorf("got %d, want 20", got)
+	}
+}
+
+func TestMemcacheStoreAdd(t *testing.T) {
+	s := newTestMemcacheStore(t)
+	ctx := context.Background()
+
+	s.Increment(ctx, "group", w1)
+	for i, tc := range []struct {
+		key   string
+		delta int64
+		want  int64
+	}{
+		{"child", 1, 1},
+		{"group", 1, 2},
+		{"child#spend", 2500, 2500},
+		{"peek", 0, 0},
+	} {
+		got, err := s.Add(ctx, tc.key, w1, tc.delta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("count %d: got %d, want %d", i, got, tc.want)
+		}
+	}
+}
+
+func TestMemcacheStoreMark(t *testing.T) {
+	s := newTestMemcacheStore(t)
+	ctx := context.Background()
+
+	for i, tc := range []struct {
+		w    store.Window
+		want bool
+	}{{w1, true}, {w1, false}, {w2, true}, {w2, false}} {
+		got, err := s.Mark(ctx, "warn", tc.w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("mark %d: got %v, want %v", i+1, got, tc.want)
+		}
+	}
+}
+
+func TestMemcacheStoreIllegalKeys(t *testing.T) {
+	s := newTestMemcacheStore(t)
+	ctx := context.Background()
+
+	for _, key := range []string{"tenant:Acme Corp", strings.Repeat("k", 300)} {
+		for i := int64(1); i <= 2; i++ {
+			got, err := s.Increment(ctx, key, w1)
+			if err != nil {
+				t.Fatalf("%.20q: %v", key, err)
+			}
+			if got != i {
+				t.Errorf("%.20q: got %d, want %d", key, got, i)
+			}
+		}
+	}
+}
+
+func TestExpiration(t *testing.T) {
+	if got := expiration(w1); got != 60 {
+		t.Errorf("minute window: got %d, want 60", got)
+	}
+	month := store.Window{
+		Duration:    31 * 24 * time.Hour,
+		BucketStart: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	if got, want := expiration(month), int32(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC).Unix()); got != want {
+		t.Errorf("long window: got %d, want %d", got, want)
+	}
+}
+
+func TestMemcacheStoreGetMany(t *testing.T) {
+	s := newTestMemcacheStore(t)
+	ctx := context.Background()
+
+	s.Increment(ctx, "stripe", w1)
+	s.Increment(ctx, "stripe", w1)
+	s.Add(ctx, "stripe#spend", w1, 1500)
+
+	got, err := s.GetMany(ctx, []store.Read{
+		{Key: "stripe", Window: w1},
+		{Key: "stripe#spend", Window: w1},
+		{Key: "missing", Window: w1},
+		{Key: "stripe", Window: w2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int64{2, 1500, 0, 0}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("count %d: got %d, want %d", i, got[i], want[i])
+		}
+	}
+}
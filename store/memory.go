@@ -10,20 +10,27 @@ type bucket struct {
 	bucketKey string
 }
 
-// Compile-time interface check.
-var _ Store = (*MemoryStore)(nil)
+// Compile-time interface checks.
+var (
+	_ Store       = (*MemoryStore)(nil)
+	_ Batcher     = (*MemoryStore)(nil)
+	_ Marker      = (*MemoryStore)(nil)
+	_ BatchGetter = (*MemoryStore)(nil)
+)
 
 // MemoryStore is an in-memory Store implementation.
 // It is safe for concurrent use. Counters are lost on process restart.
 type MemoryStore struct {
 	mu      sync.Mutex
 	buckets map[string]*bucket
+	marks   map[string]string // key -> bucket key
 }
 
 // NewMemoryStore creates a new in-memory store.
 func NewMemoryStore() *MemoryStore {
 	return &MemoryStore{
 		buckets: make(map[string]*bucket),
+		marks:   make(map[string]string),
 	}
 }
 
@@ -32,14 +39,32 @@ func (m *MemoryStore) Increment(_ context.Context, key string, w Window) (int64,
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	return m.add(key, w, 1), nil
+}
+
+// IncrementMany applies all increments under a single lock.
+func (m *MemoryStore) IncrementMany(_ context.Context, incs []Increment) ([]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make([]int64, len(incs))
+	for i, inc := range incs {
+		out[i] = m.add(inc.Key, inc.Window, inc.Delta)
+	}
+	return out, nil
+}
+
+// add adds delta to the counter for key, rolling the bucket over if needed.
+// The caller must hold m.mu.
+func (m *MemoryStore) add(key string, w Window, delta int64) int64 {
 	b, ok := m.buckets[key]
 	if !ok || b.bucketKey != w.BucketKey {
 		b = &bucket{bucketKey: w.BucketKey}
 		m.buckets[key] = b
 	}
 
-	b.count++
-	return b.count, nil
+	b.count += delta
+	return b.count
 }
 
 // Get returns the current counter value for key in the active window bucket.
@@ -47,11 +72,40 @@ func (m *MemoryStore) Get(_ context.Context, key string, w Window) (int64, error
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	return m.get(key, w), nil
+}
+
+// GetMany reads every counter under a single lock.
+func (m *MemoryStore) GetMany(_ context.Context, reads []Read) ([]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make([]int64, len(reads))
+	for i, rd := range reads {
+		out[i] = m.get(rd.Key, rd.Window)
+	}
+	return out, nil
+}
+
+// get returns the counter for key in w's bucket. The caller must hold m.mu.
+func (m *MemoryStore) get(key string, w Window) int64 {
 	b, ok := m.buckets[key]
 	if !ok || b.bucketKey != w.BucketKey {
-		return 0, nil
+		return 0
+	}
+	return b.count
+}
+
+// Mark records key in the window bucket and reports whether it was new.
+func (m *MemoryStore) Mark(_ context.Context, key string, w Window) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.marks[key] == w.BucketKey {
+		return false, nil
 	}
-	return b.count, nil
+	m.marks[key] = w.BucketKey
+	return true, nil
 }
 
 // Reset removes the counter for the given key.
@@ -92,3 +92,89 @@ func TestMemoryStoreReset(t *testing.T) {
 		t.Errorf("after reset: got %d, want 0", got)
 	}
 }
+
+func TestMemoryStoreIncrementMany(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+	w := Window{
+		Duration:    time.Minute,
+		BucketKey:   "2024-01-15T14:30",
+		BucketStart: time.Date(2024, 1, 15, 14, 30, 0, 0, time.UTC),
+	}
+
+	s.Increment(ctx, "child", w)
+
+	got, err := s.IncrementMany(ctx, []Increment{
+		{Key: "child", Window: w, Delta: 1},
+		{Key: "parent", Window: w, Delta: 5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0] != 2 || got[1] != 5 {
+		t.Errorf("counts = %v, want [2 5]", got)
+	}
+
+	if parent, _ := s.Get(ctx, "parent", w); parent != 5 {
+		t.Errorf("parent = %d, want 5", parent)
+	}
+}
+
+func TestMemoryStoreMark(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+	w1 := Window{
+		Duration:    time.Minute,
+		BucketKey:   "2024-01-15T14:30",
+		BucketStart: time.Date(2024, 1, 15, 14, 30, 0, 0, time.UTC),
+	}
+	w2 := Window{
+		Duration:    time.Minute,
+		BucketKey:   "2024-01-15T14:31",
+		BucketStart: time.Date(2024, 1, 15, 14, 31, 0, 0, time.UTC),
+	}
+
+	for i, tt := range []struct {
+		w    Window
+		want bool
+	}{
+		{w1, true},
+		{w1, false},
+		{w2, true},
+		{w2, false},
+	} {
+		got, err := s.Mark(ctx, "alert", tt.w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("mark %d: got %v, want %v", i+1, got, tt.want)
+		}
+	}
+}
+
+func TestMemoryStoreGetMany(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+	w1 := Window{Duration: time.Minute, BucketKey: "2024-01-15T14:30"}
+	w2 := Window{Duration: time.Minute, BucketKey: "2024-01-15T14:31"}
+
+	s.Increment(ctx, "a", w1)
+	s.IncrementMany(ctx, []Increment{{Key: "b", Window: w1, Delta: 7}})
+
+	got, err := s.GetMany(ctx, []Read{
+		{Key: "a", Window: w1},
+		{Key: "b", Window: w1},
+		{Key: "a", Window: w2},
+		{Key: "missing", Window: w1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int64{1, 7, 0, 0}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("count %d: got %d, want %d", i, got[i], want[i])
+		}
+	}
+}
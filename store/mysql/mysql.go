@@ -0,0 +1,369 @@
+// Package mysql provides a MySQL- and MariaDB-backed store for erl, suitable
+// for deployments where several instances share counters.
+//
+// Open the database with github.com/go-sql-driver/mysql and size its pool for
+// the number of instances sharing the server: every increment takes one
+// connection for a short transaction. A typical configuration is
+//
+//	db.SetMaxOpenConns(20)
+//	db.SetMaxIdleConns(20)
+//	db.SetConnMaxLifetime(5 * time.Minute) // below the server's wait_timeout
+//
+// The driver's clientFoundRows parameter must be left at its default (false).
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ryhazerus/erl/store"
+)
+
+// Compile-time interface checks.
+var (
+	_ store.Store         = (*MySQLStore)(nil)
+	_ store.Batcher       = (*MySQLStore)(nil)
+	_ store.Marker        = (*MySQLStore)(nil)
+	_ store.OverrideStore = (*MySQLStore)(nil)
+	_ store.BatchGetter   = (*MySQLStore)(nil)
+)
+
+// MySQLStore is a Store backed by MySQL or MariaDB. Its counter, override
+// and mark tables match SQLiteStore's original schema; it has no bucket
+// bounds or erl_history table, so it keeps no history. Each increment is an
+// INSERT ... ON DUPLICATE KEY UPDATE that also rolls the bucket over, so
+// concurrent instances never lose updates.
+type MySQLStore struct {
+	db *sql.DB
+	q  upserts
+}
+
+// NewMySQLStore creates a store using db and applies any pending schema
+// migrations.
+func NewMySQLStore(ctx context.Context, db *sql.DB) (*MySQLStore, error) {
+	if err := migrate(ctx, db); err != nil {
+		return nil, err
+	}
+	var version string
+	if err := db.QueryRowContext(ctx, "SELECT VERSION()").Scan(&version); err != nil {
+		return nil, fmt.Errorf("erl/store/mysql: read server version: %w", err)
+	}
+	return &MySQLStore{db: db, q: upsertsFor(version)}, nil
+}
+
+// migrationLock names the lock held while migrating, so instances starting
+// together apply each migration once.
+const migrationLock = "erl_schema_migrations"
+
+// migrations are applied in order; the schema version is the number applied.
+// Never edit or reorder an existing entry, only append. Keys use a binary
+// collation because counter keys are case-sensitive.
+var migrations = []string{
+	"CREATE TABLE IF NOT EXISTS erl_counters (" +
+		"`key`          VARCHAR(512) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL PRIMARY KEY," +
+		"count          BIGINT NOT NULL DEFAULT 0," +
+		"bucket_key     VARCHAR(64) NOT NULL DEFAULT ''," +
+		"window_seconds BIGINT NOT NULL DEFAULT 0" +
+		") ENGINE=InnoDB",
+	"CREATE TABLE IF NOT EXISTS erl_limit_overrides (" +
+		"resource    VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL," +
+		"`partition` VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL DEFAULT ''," +
+		"limit_value BIGINT NOT NULL," +
+		"PRIMARY KEY (resource, `partition`)" +
+		") ENGINE=InnoDB",
+	"CREATE TABLE IF NOT EXISTS erl_marks (" +
+		"`key`      VARCHAR(512) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL PRIMARY KEY," +
+		"bucket_key VARCHAR(64) NOT NULL" +
+		") ENGINE=InnoDB",
+}
+
+// migrate brings the schema up to date. MySQL commits DDL implicitly, so
+// concurrent migrations are serialised with a named lock instead of a
+// transaction.
+func migrate(ctx context.Context, db *sql.DB) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("erl/store/mysql: migrate: %w", err)
+	}
+	defer conn.Close()
+
+	var locked sql.NullInt64
+	if err := conn.QueryRowContext(ctx, `SELECT GET_LOCK(?, 30)`, migrationLock).Scan(&locked); err != nil {
+		return fmt.Errorf("erl/store/mysql: migrate: lock: %w", err)
+	}
+	if locked.Int64 != 1 {
+		return fmt.Errorf("erl/store/mysql: migrate: timed out waiting for lock %q", migrationLock)
+	}
+	defer conn.ExecContext(context.WithoutCancel(ctx), `SELECT RELEASE_LOCK(?)`, migrationLock)
+
+	if _, err := conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS erl_schema_migrations (
+			version    INT NOT NULL PRIMARY KEY,
+			applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		) ENGINE=InnoDB
+	`); err != nil {
+		return fmt.Errorf("erl/store/mysql: migrate: create migrations table: %w", err)
+	}
+
+	var version int
+	if err := conn.QueryRowContext(ctx,
+		`SELECT COALESCE(MAX(version), 0) FROM erl_schema_migrations`,
+	).Scan(&version); err != nil {
+		return fmt.Errorf("erl/store/mysql: migrate: read version: %w", err)
+	}
+
+	for i := version; i < len(migrations); i++ {
+		if _, err := conn.ExecContext(ctx, migrations[i]); err != nil {
+			return fmt.Errorf("erl/store/mysql: migrate to version %d: %w", i+1, err)
+		}
+		if _, err := conn.ExecContext(ctx,
+			`INSERT INTO erl_schema_migrations (version) VALUES (?)`, i+1,
+		); err != nil {
+			return fmt.Errorf("erl/store/mysql: migrate to version %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// upserts holds the store's INSERT ... ON DUPLICATE KEY UPDATE statements.
+//
+// counter adds to a counter, or restarts it from the delta when the stored
+// bucket differs from the current one. MySQL applies assignments left to
+// right, so count must be computed before bucket_key is overwritten.
+type upserts struct {
+	counter  string
+	mark     string
+	override string
+}
+
+// aliasUpserts refer to the inserted row through a row alias, which replaces
+// VALUES() from MySQL 8.0.20 on.
+var aliasUpserts = upserts{
+	counter: "INSERT INTO erl_counters (`key`, count, bucket_key, window_seconds) VALUES (?, ?, ?, ?) AS new " +
+		"ON DUPLICATE KEY UPDATE " +
+		"count = IF(erl_counters.bucket_key = new.bucket_key, erl_counters.count + new.count, new.count), " +
+		"bucket_key = new.bucket_key, " +
+		"window_seconds = new.window_seconds",
+	mark: "INSERT INTO erl_marks (`key`, bucket_key) VALUES (?, ?) AS new " +
+		"ON DUPLICATE KEY UPDATE bucket_key = new.bucket_key",
+	override: "INSERT INTO erl_limit_overrides (resource, `partition`, limit_value) VALUES (?, ?, ?) AS new " +
+		"ON DUPLICATE KEY UPDATE limit_value = new.limit_value",
+}
+
+// valuesUpserts use VALUES(), for MariaDB and MySQL before 8.0.19, which
+// have no row aliases.
+var valuesUpserts = upserts{
+	counter: "INSERT INTO erl_counters (`key`, count, bucket_key, window_seconds) VALUES (?, ?, ?, ?) " +
+		"ON DUPLICATE KEY UPDATE " +
+		"count = IF(bucket_key = VALUES(bucket_key), count + VALUES(count), VALUES(count)), " +
+		"bucket_key = VALUES(bucket_key), " +
+		"window_seconds = VALUES(window_seconds)",
+	mark: "INSERT INTO erl_marks (`key`, bucket_key) VALUES (?, ?) " +
+		"ON DUPLICATE KEY UPDATE bucket_key = VALUES(bucket_key)",
+	override: "INSERT INTO erl_limit_overrides (resource, `partition`, limit_value) VALUES (?, ?, ?) " +
+		"ON DUPLICATE KEY UPDATE limit_value = VALUES(limit_value)",
+}
+
+// upsertsFor returns the upserts a server with the given VERSION() supports.
+func upsertsFor(version string) upserts {
+	if strings.Contains(strings.ToLower(version), "mariadb") {
+		return valuesUpserts
+	}
+	var v [3]int
+	for i, part := range strings.SplitN(strings.SplitN(version, "-", 2)[0], ".", 3) {
+		v[i], _ = strconv.Atoi(part)
+	}
+	if v[0] > 8 || v[0] == 8 && (v[1] > 0 || v[2] >= 19) {
+		return aliasUpserts
+	}
+	return valuesUpserts
+}
+
+// Increment atomically adds one to the counter for key in the current window
+// bucket. If the bucket has rolled over, the counter is reset first.
+func (s *MySQLStore) Increment(ctx context.Context, key string, w store.Window) (int64, error) {
+	counts, err := s.IncrementMany(ctx, []store.Increment{{Key: key, Window: w, Delta: 1}})
+	if err != nil {
+		return 0, err
+	}
+	return counts[0], nil
+}
+
+// IncrementMany applies all increments in a single transaction.
+func (s *MySQLStore) IncrementMany(ctx context.Context, incs []store.Increment) ([]int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("erl/store/mysql: increment: %w", err)
+	}
+	defer tx.Rollback()
+
+	out := make([]int64, len(incs))
+	for i, inc := range incs {
+		if out[i], err = s.add(ctx, tx, inc); err != nil {
+			return nil, fmt.Errorf("erl/store/mysql: increment: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("erl/store/mysql: increment: %w", err)
+	}
+	return out, nil
+}
+
+// add applies a single increment within tx. MySQL has no RETURNING clause,
+// so the new count is read back while the upsert still holds the row lock.
+func (s *MySQLStore) add(ctx context.Context, tx *sql.Tx, inc store.Increment) (int64, error) {
+	if _, err := tx.ExecContext(ctx, s.q.counter,
+		inc.Key, inc.Delta, inc.Window.BucketKey, int64(inc.Window.Duration.Seconds()),
+	); err != nil {
+		return 0, err
+	}
+
+	var count int64
+	err := tx.QueryRowContext(ctx, "SELECT count FROM erl_counters WHERE `key` = ?", inc.Key).Scan(&count)
+	return count, err
+}
+
+// Get returns the current counter value for key in the active window bucket.
+func (s *MySQLStore) Get(ctx context.Context, key string, w store.Window) (int64, error) {
+	var count int64
+	err := s.db.QueryRowContext(ctx,
+		"SELECT count FROM erl_counters WHERE `key` = ? AND bucket_key = ?", key, w.BucketKey,
+	).Scan(&count)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("erl/store/mysql: get: %w", err)
+	}
+	return count, nil
+}
+
+// getManyChunk caps the keys read by one query, well under the driver's
+// limit on bound parameters.
+const getManyChunk = 1000
+
+// GetMany reads the counters with one SELECT ... WHERE key IN (...) per
+// getManyChunk keys.
+func (s *MySQLStore) GetMany(ctx context.Context, reads []store.Read) ([]int64, error) {
+	rows := make(map[string]counterRow, len(reads))
+	for start := 0; start < len(reads); start += getManyChunk {
+		if err := s.getChunk(ctx, reads[start:min(start+getManyChunk, len(reads))], rows); err != nil {
+			return nil, fmt.Errorf("erl/store/mysql: get many: %w", err)
+		}
+	}
+
+	out := make([]int64, len(reads))
+	for i, rd := range reads {
+		if r, ok := rows[rd.Key]; ok && r.bucketKey == rd.Window.BucketKey {
+			out[i] = r.count
+		}
+	}
+	return out, nil
+}
+
+// counterRow is a row of erl_counters read by GetMany.
+type counterRow struct {
+	count     int64
+	bucketKey string
+}
+
+// getChunk reads the rows of the keys in reads into rows.
+func (s *MySQLStore) getChunk(ctx context.Context, reads []store.Read, rows map[string]counterRow) error {
+	args := make([]any, len(reads))
+	for i, rd := range reads {
+		args[i] = rd.Key
+	}
+
+	rs, err := s.db.QueryContext(ctx,
+		"SELECT `key`, count, bucket_key FROM erl_counters WHERE `key` IN (?"+strings.Repeat(", ?", len(reads)-1)+")",
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rs.Close()
+
+	for rs.Next() {
+		var key string
+		var r counterRow
+		if err := rs.Scan(&key, &r.count, &r.bucketKey); err != nil {
+			return err
+		}
+		rows[key] = r
+	}
+	return rs.Err()
+}
+
+// Mark records key in the window bucket and reports whether it was new. An
+// upsert that leaves the row unchanged affects no rows.
+func (s *MySQLStore) Mark(ctx context.Context, key string, w store.Window) (bool, error) {
+	res, err := s.db.ExecContext(ctx, s.q.mark, key, w.BucketKey)
+	if err != nil {
+		return false, fmt.Errorf("erl/store/mysql: mark: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("erl/store/mysql: mark: %w", err)
+	}
+	return n > 0, nil
+}
+
+// Reset removes the counter for the given key.
+func (s *MySQLStore) Reset(ctx context.Context, key string) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM erl_counters WHERE `key` = ?", key); err != nil {
+		return fmt.Errorf("erl/store/mysql: reset: %w", err)
+	}
+	return nil
+}
+
+// SetOverride creates or replaces the limit override for a resource partition.
+func (s *MySQLStore) SetOverride(ctx context.Context, o store.LimitOverride) error {
+	if _, err := s.db.ExecContext(ctx, s.q.override, o.Resource, o.Partition, o.Limit); err != nil {
+		return fmt.Errorf("erl/store/mysql: set override: %w", err)
+	}
+	return nil
+}
+
+// DeleteOverride removes the limit override for a resource partition.
+func (s *MySQLStore) DeleteOverride(ctx context.Context, resource, partition string) error {
+	_, err := s.db.ExecContext(ctx,
+		"DELETE FROM erl_limit_overrides WHERE resource = ? AND `partition` = ?", resource, partition,
+	)
+	if err != nil {
+		return fmt.Errorf("erl/store/mysql: delete override: %w", err)
+	}
+	return nil
+}
+
+// Overrides returns every stored limit override.
+func (s *MySQLStore) Overrides(ctx context.Context) ([]store.LimitOverride, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT resource, `partition`, limit_value FROM erl_limit_overrides ORDER BY resource, `partition`",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("erl/store/mysql: overrides: %w", err)
+	}
+	defer rows.Close()
+
+	var out []store.LimitOverride
+	for rows.Next() {
+		var o store.LimitOverride
+		if err := rows.Scan(&o.Resource, &o.Partition, &o.Limit); err != nil {
+			return nil, fmt.Errorf("erl/store/mysql: overrides: %w", err)
+		}
+		out = append(out, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("erl/store/mysql: overrides: %w", err)
+	}
+	return out, nil
+}
+
+// Close closes the underlying database handle.
+func (s *MySQLStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,269 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/ryhazerus/erl/store"
+)
+
+// newTestMySQLStore connects to the database named by ERL_MYSQL_DSN,
+// e.g. "root:root@tcp(localhost:3306)/erl_test", and empties
+// the erl tables. Tests are skipped when it is unset.
+func newTestMySQLStore(t *testing.T) *MySQLStore {
+	t.Helper()
+	dsn := os.Getenv("ERL_MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("ERL_MYSQL_DSN not set")
+	}
+
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewMySQLStore(context.Background(), db)
+	if err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	for _, table := range []string{"erl_counters", "erl_limit_overrides", "erl_marks"} {
+		if _, err := db.Exec("TRUNCATE TABLE " + table); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+var (
+	w1 = store.Window{
+		Duration:    time.Minute,
+		BucketKey:   "2024-01-15T14:30",
+		BucketStart: time.Date(2024, 1, 15, 14, 30, 0, 0, time.UTC),
+	}
+	w2 = store.Window{
+		Duration:    time.Minute,
+		BucketKey:   "2024-01-15T14:31",
+		BucketStart: time.Date(2024, 1, 15, 14, 31, 0, 0, time.UTC),
+	}
+)
+
+func TestMySQLStoreIncrement(t *testing.T) {
+	s := newTestMySQLStore(t)
+	ctx := context.Background()
+
+	for i := int64(1); i <= 5; i++ {
+		got, err := s.Increment(ctx, "test", w1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != i {
+			t.Errorf("increment %d: got %d, want %d", i, got, i)
+		}
+	}
+}
+
+func TestMySQLStoreWindowRollover(t *testing.T) {
+	s := newTestMySQLStore(t)
+	ctx := context.Background()
+
+	s.Increment(ctx, "key", w1)
+	s.Increment(ctx, "key", w1)
+
+	got, err := s.Increment(ctx, "key", w2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 1 {
+		t.Errorf("after rollover: got %d, want 1", got)
+	}
+	if got, _ := s.Get(ctx, "key", w1); got != 0 {
+		t.Errorf("old bucket: got %d, want 0", got)
+	}
+}
+
+func TestMySQLStoreGetAndReset(t *testing.T) {
+	s := newTestMySQLStore(t)
+	ctx := context.Background()
+
+	if got, err := s.Get(ctx, "key", w1); err != nil || got != 0 {
+		t.Fatalf("initial get: got %d, %v; want 0", got, err)
+	}
+	s.Increment(ctx, "key", w1)
+	s.Increment(ctx, "key", w1)
+	if got, _ := s.Get(ctx, "key", w1); got != 2 {
+		t.Errorf("get: got %d, want 2", got)
+	}
+
+	if err := s.Reset(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.Get(ctx, "key", w1); got != 0 {
+		t.Errorf("after reset: got %d, want 0", got)
+	}
+}
+
+func TestMySQLStoreKeysAreCaseSensitive(t *testing.T) {
+	s := newTestMySQLStore(t)
+	ctx := context.Background()
+
+	s.Increment(ctx, "openai:Acme", w1)
+	if got, _ := s.Increment(ctx, "openai:acme", w1); got != 1 {
+		t.Errorf("got %d, want 1", got)
+	}
+}
+
+func TestMySQLStoreConcurrentIncrements(t *testing.T) {
+	s := newTestMySQLStore(t)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Increment(ctx, "shared", w1); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got, _ := s.Get(ctx, "shared", w1); got != 50 {
+		t.Errorf("got %d, want 50", got)
+	}
+}
+
+func TestMySQLStoreIncrementMany(t *testing.T) {
+	s := newTestMySQLStore(t)
+	ctx := context.Background()
+
+	s.Increment(ctx, "group", w1)
+	got, err := s.IncrementMany(ctx, []store.Increment{
+		{Key: "child", Window: w1, Delta: 1},
+		{Key: "group", Window: w1, Delta: 1},
+		{Key: "child#spend", Window: w1, Delta: 2500},
+		{Key: "peek", Window: w1, Delta: 0},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int64{1, 2, 2500, 0}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("count %d: got %d, want %d", i, got[i], want[i])
+		}
+	}
+
+	got, err = s.IncrementMany(ctx, []store.Increment{{Key: "group", Window: w2, Delta: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0] != 3 {
+		t.Errorf("after rollover: got %d, want 3", got[0])
+	}
+}
+
+func TestMySQLStoreMark(t *testing.T) {
+	s := newTestMySQLStore(t)
+	ctx := context.Background()
+
+	for i, tc := range []struct {
+		w    store.Window
+		want bool
+	}{{w1, true}, {w1, false}, {w2, true}, {w2, false}} {
+		got, err := s.Mark(ctx, "warn", tc.w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("mark %d: got %v, want %v", i+1, got, tc.want)
+		}
+	}
+}
+
+func TestMySQLStoreOverrides(t *testing.T) {
+	s := newTestMySQLStore(t)
+	ctx := context.Background()
+
+	s.SetOverride(ctx, store.LimitOverride{Resource: "openai", Partition: "acme", Limit: 100})
+	s.SetOverride(ctx, store.LimitOverride{Resource: "openai", Partition: "acme", Limit: 500})
+	s.SetOverride(ctx, store.LimitOverride{Resource: "openai", Partition: "globex", Limit: 50})
+	if err := s.DeleteOverride(ctx, "openai", "globex"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Overrides(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != (store.LimitOverride{Resource: "openai", Partition: "acme", Limit: 500}) {
+		t.Errorf("overrides = %+v", got)
+	}
+}
+
+func TestMySQLStoreMigrateTwice(t *testing.T) {
+	s := newTestMySQLStore(t)
+	if err := migrate(context.Background(), s.db); err != nil {
+		t.Fatalf("second migration: %v", err)
+	}
+
+	var version int
+	if err := s.db.QueryRow(`SELECT MAX(version) FROM erl_schema_migrations`).Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != len(migrations) {
+		t.Errorf("version = %d, want %d", version, len(migrations))
+	}
+}
+
+func TestMySQLStoreGetMany(t *testing.T) {
+	s := newTestMySQLStore(t)
+	ctx := context.Background()
+
+	s.Increment(ctx, "stripe", w1)
+	s.Increment(ctx, "stripe", w1)
+	s.IncrementMany(ctx, []store.Increment{{Key: "stripe#spend", Window: w1, Delta: 1500}})
+
+	got, err := s.GetMany(ctx, []store.Read{
+		{Key: "stripe", Window: w1},
+		{Key: "stripe#spend", Window: w1},
+		{Key: "missing", Window: w1},
+		{Key: "stripe", Window: w2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int64{2, 1500, 0, 0}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("count %d: got %d, want %d", i, got[i], want[i])
+		}
+	}
+}
+
+func TestUpsertsFor(t *testing.T) {
+	for _, tt := range []struct {
+		version string
+		want    upserts
+	}{
+		{"8.4.2", aliasUpserts},
+		{"8.0.36-0ubuntu0.22.04.1", aliasUpserts},
+		{"8.0.19", aliasUpserts},
+		{"8.0.18", valuesUpserts},
+		{"9.1.0", aliasUpserts},
+		{"5.7.44-log", valuesUpserts},
+		{"10.11.6-MariaDB-0+deb12u1", valuesUpserts},
+		{"11.4.2-MariaDB", valuesUpserts},
+	} {
+		if got := upsertsFor(tt.version); got != tt.want {
+			t.Errorf("upsertsFor(%q) = %q, want %q", tt.version, got.mark, tt.want.mark)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+// Package nats provides a store for erl built on NATS JetStream key-value
+// buckets, for deployments that already run NATS and want shared counters
+// without Redis.
+package nats
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/ryhazerus/erl/store"
+)
+
+// Compile-time interface checks.
+var (
+	_ store.Store       = (*NATSStore)(nil)
+	_ store.Adder       = (*NATSStore)(nil)
+	_ store.Marker      = (*NATSStore)(nil)
+	_ store.BatchGetter = (*NATSStore)(nil)
+)
+
+// markerTTL is how long the bucket keeps the delete markers left behind when
+// a counter expires.
+const markerTTL = time.Minute
+
+// NATSStore is a Store backed by a JetStream key-value bucket. Each counter
+// is one key holding its count and window bucket. Updates are
+// compare-and-swap writes against the key's last revision, retried on
+// conflict, and every write carries a TTL that expires the key when its
+// window bucket ends. Per-key TTLs need NATS Server 2.11 or later.
+//
+// JetStream has no multi-key transactions, so the store implements
+// store.Adder rather than store.Batcher: a resource and its quota groups are
+// updated one at a time, and if an update fails part way, earlier counters
+// stay incremented.
+type NATSStore struct {
+	nc     *nats.Conn
+	js     jetstream.JetStream
+	kv     jetstream.KeyValue
+	stream jetstream.Stream
+	prefix string // subject prefix of the bucket's keys
+}
+
+// NewNATSStore creates a store in the named key-value bucket, creating the
+// bucket or enabling per-key TTLs on it as needed.
+func NewNATSStore(ctx context.Context, nc *nats.Conn, bucket string) (*NATSStore, error) {
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, fmt.Errorf("erl/store/nats: %w", err)
+	}
+	kv, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:         bucket,
+		Description:    "erl rate limit counters",
+		History:        1,
+		LimitMarkerTTL: markerTTL,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("erl/store/nats: create bucket: %w", err)
+	}
+	stream, err := js.Stream(ctx, "KV_"+bucket)
+	if err != nil {
+		return nil, fmt.Errorf("erl/store/nats: open bucket stream: %w", err)
+	}
+	return &NATSStore{nc: nc, js: js, kv: kv, stream: stream, prefix: "$KV." + bucket + "."}, nil
+}
+
+// Increment atomically adds one to the counter for key in the current window
+// bucket. If the window bucket has rolled over, the counter is reset first.
+func (s *NATSStore) Increment(ctx context.Context, key string, w store.Window) (int64, error) {
+	count, err := s.add(ctx, key, w, 1)
+	if err != nil {
+		return 0, fmt.Errorf("erl/store/nats: increment: %w", err)
+	}
+	return count, nil
+}
+
+// Add adds delta to the counter for key in the current window bucket,
+// resetting it first if the bucket has rolled over.
+func (s *NATSStore) Add(ctx context.Context, key string, w store.Window, delta int64) (int64, error) {
+	count, err := s.add(ctx, key, w, delta)
+	if err != nil {
+		return 0, fmt.Errorf("erl/store/nats: add: %w", err)
+	}
+	return count, nil
+}
+
+// add adds delta to the counter for key, rolling the window bucket over if
+// needed, and retries when another writer updated the key first.
+func (s *NATSStore) add(ctx context.Context, key string, w store.Window, delta int64) (int64, error) {
+	if delta == 0 {
+		return s.get(ctx, key, w)
+	}
+	for {
+		value, rev, err := s.latest(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+
+		var count int64
+		if bucket, n, ok := decode(value); ok && bucket == w.BucketKey {
+			count = n
+		}
+		count += delta
+
+		err = s.write(ctx, key, encode(w.BucketKey, count), rev, w)
+		if err == nil {
+			return count, nil
+		}
+		if !conflict(err) {
+			return 0, err
+		}
+	}
+}
+
+// Get returns the current counter value for key in the active window bucket.
+func (s *NATSStore) Get(ctx context.Context, key string, w store.Window) (int64, error) {
+	count, err := s.get(ctx, key, w)
+	if err != nil {
+		return 0, fmt.Errorf("erl/store/nats: get: %w", err)
+	}
+	return count, nil
+}
+
+// getManyConcurrency caps the reads GetMany has in flight at once.
+const getManyConcurrency = 32
+
+// GetMany reads every counter. JetStream has no multi-key read, so the gets
+// are issued concurrently over the connection and their round trips overlap.
+func (s *NATSStore) GetMany(ctx context.Context, reads []store.Read) ([]int64, error) {
+	out := make([]int64, len(reads))
+	errs := make([]error, len(reads))
+	sem := make(chan struct{}, getManyConcurrency)
+	var wg sync.WaitGroup
+	for i, rd := range reads {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			out[i], errs[i] = s.get(ctx, rd.Key, rd.Window)
+		}()
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("erl/store/nats: get many: %w", err)
+	}
+	return out, nil
+}
+
+func (s *NATSStore) get(ctx context.Context, key string, w store.Window) (int64, error) {
+	e, err := s.kv.Get(ctx, kvKey(key))
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if bucket, n, ok := decode(e.Value()); ok && bucket == w.BucketKey {
+		return n, nil
+	}
+	return 0, nil
+}
+
+// Mark records key in the window bucket and reports whether this call was
+// the first to do so.
+func (s *NATSStore) Mark(ctx context.Context, key string, w store.Window) (bool, error) {
+	for {
+		value, rev, err := s.latest(ctx, key)
+		if err != nil {
+			return false, fmt.Errorf("erl/store/nats: mark: %w", err)
+		}
+		if bucket, _, ok := decode(value); ok && bucket == w.BucketKey {
+			return false, nil
+		}
+
+		err = s.write(ctx, key, encode(w.BucketKey, 1), rev, w)
+		if err == nil {
+			return true, nil
+		}
+		if !conflict(err) {
+			return false, fmt.Errorf("erl/store/nats: mark: %w", err)
+		}
+	}
+}
+
+// Reset removes the counter for the given key.
+func (s *NATSStore) Reset(ctx context.Context, key string) error {
+	err := s.kv.Purge(ctx, kvKey(key))
+	if err != nil {
+		return fmt.Errorf("erl/store/nats: reset: %w", err)
+	}
+	return nil
+}
+
+// Close closes the underlying NATS connection.
+func (s *NATSStore) Close() error {
+	s.nc.Close()
+	return nil
+}
+
+// latest returns the current value of key and the revision the next write
+// must expect. The revision is non-zero after a delete, purge or expiry,
+// which leave a marker with no value.
+func (s *NATSStore) latest(ctx context.Context, key string) ([]byte, uint64, error) {
+	m, err := s.stream.GetLastMsgForSubject(ctx, s.prefix+kvKey(key))
+	if errors.Is(err, jetstream.ErrMsgNotFound) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if m.Header.Get("KV-Operation") != "" || m.Header.Get(jetstream.MarkerReasonHeader) != "" {
+		return nil, m.Sequence, nil
+	}
+	return m.Data, m.Sequence, nil
+}
+
+// write stores value under key if its last revision is still rev, expiring
+// it when w's bucket ends.
+func (s *NATSStore) write(ctx context.Context, key string, value []byte, rev uint64, w store.Window) error {
+	_, err := s.js.PublishMsg(ctx,
+		&nats.Msg{Subject: s.prefix + kvKey(key), Data: value},
+		jetstream.WithExpectLastSequencePerSubject(rev),
+		jetstream.WithMsgTTL(ttl(w)),
+	)
+	return err
+}
+
+// conflict reports whether err means another writer changed the key since
+// it was read.
+func conflict(err error) bool {
+	var apiErr *jetstream.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode == jetstream.JSErrCodeStreamWrongLastSequence
+}
+
+// ttl returns how long a write in w should live: until the bucket ends,
+// rounded up to the whole seconds JetStream supports.
+func ttl(w store.Window) time.Duration {
+	d := w.Duration
+	if !w.BucketStart.IsZero() {
+		d = time.Until(w.BucketStart.Add(w.Duration))
+	}
+	return max((d + time.Second - 1).Truncate(time.Second), time.Second)
+}
+
+// kvKey maps a store key onto the characters JetStream allows in keys.
+func kvKey(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// encode and decode store a counter as "<bucket key> <count>".
+func encode(bucket string, count int64) []byte {
+	return []byte(bucket + " " + strconv.FormatInt(count, 10))
+}
+
+func decode(value []byte) (bucket string, count int64, ok bool) {
+	bucket, n, ok := strings.Cut(string(value), " ")
+	if !ok {
+		return "", 0, false
+	}
+	count, err := strconv.ParseInt(n, 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return bucket, count, true
+}
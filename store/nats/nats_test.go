@@ -0,0 +1,239 @@
+package nats
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/ryhazerus/erl/store"
+)
+
+func newTestNATSStore(t *testing.T) *NATSStore {
+	t.Helper()
+	srv, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		NoLog:     true,
+		NoSigs:    true,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Start()
+	t.Cleanup(srv.Shutdown)
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats-server did not start")
+	}
+
+	nc, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewNATSStore(context.Background(), nc, "erl")
+	if err != nil {
+		nc.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// window returns the minute bucket containing t.
+func window(t time.Time) store.Window {
+	start := t.UTC().Truncate(time.Minute)
+	return store.Window{
+		Duration:    time.Minute,
+		BucketKey:   start.Format("2006-01-02T15:04"),
+		BucketStart: start,
+	}
+}
+
+func TestNATSStoreIncrement(t *testing.T) {
+	s := newTestNATSStore(t)
+	ctx := context.Background()
+	w := window(time.Now())
+
+	for i := int64(1); i <= 5; i++ {
+		got, err := s.Increment(ctx, "test", w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != i {
+			t.Errorf("increment %d: got %d, want %d", i, got, i)
+		}
+	}
+}
+
+func TestNATSStoreWindowRollover(t *testing.T) {
+	s := newTestNATSStore(t)
+	ctx := context.Background()
+	w1 := window(time.Now())
+	w2 := window(time.Now().Add(time.Minute))
+
+	s.Increment(ctx, "key", w1)
+	s.Increment(ctx, "key", w1)
+
+	if got, _ := s.Increment(ctx, "key", w2); got != 1 {
+		t.Errorf("after rollover: got %d, want 1", got)
+	}
+	if got, _ := s.Get(ctx, "key", w1); got != 0 {
+		t.Errorf("old bucket: got %d, want 0", got)
+	}
+}
+
+func TestNATSStoreGetAndReset(t *testing.T) {
+	s := newTestNATSStore(t)
+	ctx := context.Background()
+	w := window(time.Now())
+
+	if got, err := s.Get(ctx, "tenant:Acme Corp", w); err != nil || got != 0 {
+		t.Fatalf("initial get: got %d, %v; want 0", got, err)
+	}
+	s.Increment(ctx, "tenant:Acme Corp", w)
+	s.Increment(ctx, "tenant:Acme Corp", w)
+	if got, _ := s.Get(ctx, "tenant:Acme Corp", w); got != 2 {
+		t.Errorf("get: got %d, want 2", got)
+	}
+
+	if err := s.Reset(ctx, "tenant:Acme Corp"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.Get(ctx, "tenant:Acme Corp", w); got != 0 {
+		t.Errorf("after reset: got %d, want 0", got)
+	}
+	// A reset key can be counted again.
+	if got, err := s.Increment(ctx, "tenant:Acme Corp", w); err != nil || got != 1 {
+		t.Errorf("after reset: got %d, %v; want 1", got, err)
+	}
+}
+
+func TestNATSStoreConcurrentIncrements(t *testing.T) {
+	s := newTestNATSStore(t)
+	ctx := context.Background()
+	w := window(time.Now())
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Increment(ctx, "shared", w); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got, _ := s.Get(ctx, "shared", w); got != 20 {
+		t.Errorf("got %d, want 20", got)
+	}
+}
+
+func TestNATSStoreAdd(t *testing.T) {
+	s := newTestNATSStore(t)
+	ctx := context.Background()
+	w := window(time.Now())
+
+	s.Increment(ctx, "group", w)
+	for i, tc := range []struct {
+		key   string
+		delta int64
+		want  int64
+	}{
+		{"child", 1, 1},
+		{"group", 1, 2},
+		{"child#spend", 2500, 2500},
+		{"peek", 0, 0},
+	} {
+		got, err := s.Add(ctx, tc.key, w, tc.delta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("count %d: got %d, want %d", i, got, tc.want)
+		}
+	}
+}
+
+func TestNATSStoreMark(t *testing.T) {
+	s := newTestNATSStore(t)
+	ctx := context.Background()
+	w1 := window(time.Now())
+	w2 := window(time.Now().Add(time.Minute))
+
+	for i, tc := range []struct {
+		w    store.Window
+		want bool
+	}{{w1, true}, {w1, false}, {w2, true}, {w2, false}} {
+		got, err := s.Mark(ctx, "warn", tc.w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("mark %d: got %v, want %v", i+1, got, tc.want)
+		}
+	}
+}
+
+func TestNATSStoreKeyExpires(t *testing.T) {
+	s := newTestNATSStore(t)
+	ctx := context.Background()
+
+	// A bucket that ends within a second.
+	w := store.Window{
+		Duration:    time.Second,
+		BucketKey:   "short",
+		BucketStart: time.Now(),
+	}
+	if _, err := s.Increment(ctx, "short-lived", w); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if got, _ := s.Get(ctx, "short-lived", w); got == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("counter did not expire")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// The expiry marker does not block new writes.
+	if got, err := s.Increment(ctx, "short-lived", w); err != nil || got != 1 {
+		t.Errorf("after expiry: got %d, %v; want 1", got, err)
+	}
+}
+
+func TestNATSStoreGetMany(t *testing.T) {
+	s := newTestNATSStore(t)
+	ctx := context.Background()
+	w1 := window(time.Now())
+	w2 := window(time.Now().Add(time.Minute))
+
+	s.Increment(ctx, "stripe", w1)
+	s.Increment(ctx, "stripe", w1)
+	s.Add(ctx, "stripe#spend", w1, 1500)
+
+	got, err := s.GetMany(ctx, []store.Read{
+		{Key: "stripe", Window: w1},
+		{Key: "stripe#spend", Window: w1},
+		{Key: "missing", Window: w1},
+		{Key: "stripe", Window: w2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int64{2, 1500, 0, 0}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("count %d: got %d, want %d", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package store
+
+import "context"
+
+// LimitOverride replaces a resource's configured limit for one partition,
+// e.g. a tenant on a larger plan.
+type LimitOverride struct {
+	Resource  string
+	Partition string
+	Limit     int64
+}
+
+// OverrideStore is implemented by stores that can persist limit overrides.
+type OverrideStore interface {
+	// SetOverride creates or replaces the override for o.Resource and o.Partition.
+	SetOverride(ctx context.Context, o LimitOverride) error
+
+	// DeleteOverride removes the override for a resource partition, if any.
+	DeleteOverride(ctx context.Context, resource, partition string) error
+
+	// Overrides returns every stored override.
+	Overrides(ctx context.Context) ([]LimitOverride, error)
+}
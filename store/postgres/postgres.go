@@ -0,0 +1,481 @@
+// Package postgres provides a PostgreSQL-backed store for erl, suitable for
+// deployments where several instances share counters.
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ryhazerus/erl/store"
+)
+
+// Compile-time interface checks.
+var (
+	_ store.Store         = (*PostgresStore)(nil)
+	_ store.Batcher       = (*PostgresStore)(nil)
+	_ store.Marker        = (*PostgresStore)(nil)
+	_ store.OverrideStore = (*PostgresStore)(nil)
+	_ store.BatchGetter   = (*PostgresStore)(nil)
+	_ store.HistoryStore  = (*PostgresStore)(nil)
+	_ store.KeyLister     = (*PostgresStore)(nil)
+)
+
+// PostgresStore is a Store backed by PostgreSQL. It uses the same tables as
+// SQLiteStore. Each increment is a single INSERT ... ON CONFLICT DO UPDATE
+// statement that also rolls the bucket over, so concurrent instances never
+// lose updates.
+type PostgresStore struct {
+	db        *sql.DB
+	retention time.Duration
+	noMigrate bool
+}
+
+// Option configures a PostgresStore.
+type Option func(*PostgresStore)
+
+// WithHistory archives the final count of each window bucket in the
+// erl_history table when a counter rolls over, and keeps archived buckets
+// for retention after they start. History is off by default.
+func WithHistory(retention time.Duration) Option {
+	return func(s *PostgresStore) {
+		s.retention = retention
+	}
+}
+
+// WithoutMigrations makes NewPostgresStore check that the schema is up to
+// date instead of migrating it, and fail if it is behind. Read-only tools
+// such as the erl command use it so they never take the migration lock or
+// run DDL.
+func WithoutMigrations() Option {
+	return func(s *PostgresStore) {
+		s.noMigrate = true
+	}
+}
+
+// NewPostgresStore creates a store using db, which must be opened with a
+// PostgreSQL driver such as github.com/jackc/pgx/v5/stdlib, and applies any
+// pending schema migrations. Configure the pool on db itself, e.g. with
+// SetMaxOpenConns.
+func NewPostgresStore(ctx context.Context, db *sql.DB, opts ...Option) (*PostgresStore, error) {
+	s := &PostgresStore{db: db}
+	for _, o := range opts {
+		o(s)
+	}
+	check := migrate
+	if s.noMigrate {
+		check = checkSchema
+	}
+	if err := check(ctx, db); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// migrationLock is the advisory lock key held while migrating, so instances
+// starting together apply each migration once.
+const migrationLock = 0x65726c // "erl"
+
+// migrations are applied in order; the schema version is the number applied.
+// Never edit or reorder an existing entry, only append.
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS erl_counters (
+		key            TEXT PRIMARY KEY,
+		count          BIGINT NOT NULL DEFAULT 0,
+		bucket_key     TEXT NOT NULL DEFAULT '',
+		window_seconds BIGINT NOT NULL DEFAULT 0
+	)`,
+	`CREATE TABLE IF NOT EXISTS erl_limit_overrides (
+		resource    TEXT NOT NULL,
+		partition   TEXT NOT NULL DEFAULT '',
+		limit_value BIGINT NOT NULL,
+		PRIMARY KEY (resource, partition)
+	)`,
+	`CREATE TABLE IF NOT EXISTS erl_marks (
+		key        TEXT PRIMARY KEY,
+		bucket_key TEXT NOT NULL
+	)`,
+	// Bucket bounds in Unix milliseconds, for history.
+	`ALTER TABLE erl_counters
+		ADD COLUMN IF NOT EXISTS bucket_start BIGINT NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS bucket_end   BIGINT NOT NULL DEFAULT 0`,
+	`CREATE TABLE IF NOT EXISTS erl_history (
+		key          TEXT NOT NULL,
+		bucket_key   TEXT NOT NULL,
+		bucket_start BIGINT NOT NULL,
+		bucket_end   BIGINT NOT NULL,
+		count        BIGINT NOT NULL,
+		PRIMARY KEY (key, bucket_start)
+	)`,
+}
+
+// migrate brings the schema up to date in one transaction.
+func migrate(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("erl/store/postgres: migrate: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLock); err != nil {
+		return fmt.Errorf("erl/store/postgres: migrate: lock: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS erl_schema_migrations (
+			version    INTEGER PRIMARY KEY,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)
+	`); err != nil {
+		return fmt.Errorf("erl/store/postgres: migrate: create migrations table: %w", err)
+	}
+
+	var version int
+	if err := tx.QueryRowContext(ctx,
+		`SELECT COALESCE(MAX(version), 0) FROM erl_schema_migrations`,
+	).Scan(&version); err != nil {
+		return fmt.Errorf("erl/store/postgres: migrate: read version: %w", err)
+	}
+
+	for i := version; i < len(migrations); i++ {
+		if _, err := tx.ExecContext(ctx, migrations[i]); err != nil {
+			return fmt.Errorf("erl/store/postgres: migrate to version %d: %w", i+1, err)
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO erl_schema_migrations (version) VALUES ($1)`, i+1,
+		); err != nil {
+			return fmt.Errorf("erl/store/postgres: migrate to version %d: %w", i+1, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("erl/store/postgres: migrate: %w", err)
+	}
+	return nil
+}
+
+// checkSchema fails unless every migration has been applied.
+func checkSchema(ctx context.Context, db *sql.DB) error {
+	var version int
+	if err := db.QueryRowContext(ctx,
+		`SELECT COALESCE(MAX(version), 0) FROM erl_schema_migrations`,
+	).Scan(&version); err != nil {
+		return fmt.Errorf("erl/store/postgres: read schema version: %w", err)
+	}
+	if version < len(migrations) {
+		return fmt.Errorf("erl/store/postgres: schema version %d is behind %d; run NewPostgresStore with migrations first", version, len(migrations))
+	}
+	return nil
+}
+
+// incrementQuery adds to a counter, or restarts it from the delta when the
+// stored bucket differs from the current one.
+const incrementQuery = `
+	INSERT INTO erl_counters AS c (key, count, bucket_key, window_seconds, bucket_start, bucket_end)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (key) DO UPDATE SET
+		count = CASE WHEN c.bucket_key = excluded.bucket_key
+			THEN c.count + excluded.count
+			ELSE excluded.count
+		END,
+		bucket_key     = excluded.bucket_key,
+		window_seconds = excluded.window_seconds,
+		bucket_start   = excluded.bucket_start,
+		bucket_end     = excluded.bucket_end
+	RETURNING count`
+
+// archiveIncrementQuery is incrementQuery preceded by archiving the closed
+// bucket when the counter rolls over, and pruning archived buckets that
+// started before $7. The row lock taken by prev makes a concurrent rollover
+// re-read the row and find it already in the new bucket.
+const archiveIncrementQuery = `
+	WITH prev AS (
+		SELECT key, bucket_key, bucket_start, bucket_end, count FROM erl_counters
+		WHERE key = $1 AND bucket_key <> $3 AND bucket_start > 0 AND count > 0
+		FOR UPDATE
+	), archived AS (
+		INSERT INTO erl_history (key, bucket_key, bucket_start, bucket_end, count)
+		SELECT key, bucket_key, bucket_start, bucket_end, count FROM prev
+		ON CONFLICT (key, bucket_start) DO UPDATE SET
+			bucket_key = excluded.bucket_key,
+			bucket_end = excluded.bucket_end,
+			count      = excluded.count
+	), pruned AS (
+		DELETE FROM erl_history
+		WHERE key = $1 AND bucket_start < $7 AND EXISTS (SELECT 1 FROM prev)
+	)` + incrementQuery
+
+// Increment atomically adds one to the counter for key in the current window
+// bucket. If the bucket has rolled over, the counter is reset first.
+func (s *PostgresStore) Increment(ctx context.Context, key string, w store.Window) (int64, error) {
+	count, err := s.add(ctx, s.db, store.Increment{Key: key, Window: w, Delta: 1})
+	if err != nil {
+		return 0, fmt.Errorf("erl/store/postgres: increment: %w", err)
+	}
+	return count, nil
+}
+
+// IncrementMany applies all increments in a single transaction.
+func (s *PostgresStore) IncrementMany(ctx context.Context, incs []store.Increment) ([]int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("erl/store/postgres: increment many: %w", err)
+	}
+	defer tx.Rollback()
+
+	out := make([]int64, len(incs))
+	for i, inc := range incs {
+		if out[i], err = s.add(ctx, tx, inc); err != nil {
+			return nil, fmt.Errorf("erl/store/postgres: increment many: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("erl/store/postgres: increment many: %w", err)
+	}
+	return out, nil
+}
+
+// querier is satisfied by *sql.DB and *sql.Tx.
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// add applies a single increment with q.
+func (s *PostgresStore) add(ctx context.Context, q querier, inc store.Increment) (int64, error) {
+	start, end := bucketBounds(inc.Window)
+	query := incrementQuery
+	args := []any{inc.Key, inc.Delta, inc.Window.BucketKey, int64(inc.Window.Duration.Seconds()), start, end}
+	if s.retention > 0 {
+		query = archiveIncrementQuery
+		args = append(args, time.Now().Add(-s.retention).UnixMilli())
+	}
+
+	var count int64
+	err := q.QueryRowContext(ctx, query, args...).Scan(&count)
+	return count, err
+}
+
+// History returns the archived buckets of key that started in [from, to),
+// followed by the counter's current bucket if it started in that range.
+func (s *PostgresStore) History(ctx context.Context, key string, from, to time.Time) ([]store.Bucket, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT bucket_key, bucket_start, bucket_end, count FROM erl_history
+		WHERE key = $1 AND bucket_start >= $2 AND bucket_start < $3
+		UNION ALL
+		SELECT bucket_key, bucket_start, bucket_end, count FROM erl_counters
+		WHERE key = $1 AND bucket_start > 0 AND bucket_start >= $2 AND bucket_start < $3
+		ORDER BY bucket_start`,
+		key, from.UnixMilli(), to.UnixMilli(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("erl/store/postgres: history: %w", err)
+	}
+	defer rows.Close()
+
+	var out []store.Bucket
+	for rows.Next() {
+		var b store.Bucket
+		var start, end int64
+		if err := rows.Scan(&b.Key, &start, &end, &b.Count); err != nil {
+			return nil, fmt.Errorf("erl/store/postgres: history: %w", err)
+		}
+		b.Start, b.End = time.UnixMilli(start), time.UnixMilli(end)
+		out = append(out, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("erl/store/postgres: history: %w", err)
+	}
+	return out, nil
+}
+
+// Keys returns the keys starting with prefix that have a counter or archived
+// buckets.
+func (s *PostgresStore) Keys(ctx context.Context, prefix string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT key FROM erl_counters WHERE left(key, char_length($1)) = $1
+		UNION
+		SELECT key FROM erl_history WHERE left(key, char_length($1)) = $1`,
+		prefix,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("erl/store/postgres: keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("erl/store/postgres: keys: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("erl/store/postgres: keys: %w", err)
+	}
+	return keys, nil
+}
+
+// bucketBounds returns when w's bucket starts and ends in Unix milliseconds,
+// or zeros for a window without a start.
+func bucketBounds(w store.Window) (start, end int64) {
+	if w.BucketStart.IsZero() {
+		return 0, 0
+	}
+	return w.BucketStart.UnixMilli(), w.BucketStart.Add(w.Duration).UnixMilli()
+}
+
+// Get returns the current counter value for key in the active window bucket.
+func (s *PostgresStore) Get(ctx context.Context, key string, w store.Window) (int64, error) {
+	var count int64
+	err := s.db.QueryRowContext(ctx,
+		`SELECT count FROM erl_counters WHERE key = $1 AND bucket_key = $2`, key, w.BucketKey,
+	).Scan(&count)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("erl/store/postgres: get: %w", err)
+	}
+	return count, nil
+}
+
+// getManyChunk caps the keys read by one query, well under the driver's
+// limit on bound parameters.
+const getManyChunk = 1000
+
+// GetMany reads the counters with one SELECT ... WHERE key IN (...) per
+// getManyChunk keys.
+func (s *PostgresStore) GetMany(ctx context.Context, reads []store.Read) ([]int64, error) {
+	rows := make(map[string]counterRow, len(reads))
+	for start := 0; start < len(reads); start += getManyChunk {
+		if err := s.getChunk(ctx, reads[start:min(start+getManyChunk, len(reads))], rows); err != nil {
+			return nil, fmt.Errorf("erl/store/postgres: get many: %w", err)
+		}
+	}
+
+	out := make([]int64, len(reads))
+	for i, rd := range reads {
+		if r, ok := rows[rd.Key]; ok && r.bucketKey == rd.Window.BucketKey {
+			out[i] = r.count
+		}
+	}
+	return out, nil
+}
+
+// counterRow is a row of erl_counters read by GetMany.
+type counterRow struct {
+	count     int64
+	bucketKey string
+}
+
+// getChunk reads the rows of the keys in reads into rows.
+func (s *PostgresStore) getChunk(ctx context.Context, reads []store.Read, rows map[string]counterRow) error {
+	args := make([]any, len(reads))
+	params := make([]string, len(reads))
+	for i, rd := range reads {
+		args[i] = rd.Key
+		params[i] = "$" + strconv.Itoa(i+1)
+	}
+
+	rs, err := s.db.QueryContext(ctx,
+		`SELECT key, count, bucket_key FROM erl_counters WHERE key IN (`+strings.Join(params, ", ")+`)`,
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rs.Close()
+
+	for rs.Next() {
+		var key string
+		var r counterRow
+		if err := rs.Scan(&key, &r.count, &r.bucketKey); err != nil {
+			return err
+		}
+		rows[key] = r
+	}
+	return rs.Err()
+}
+
+// Mark records key in the window bucket and reports whether it was new.
+func (s *PostgresStore) Mark(ctx context.Context, key string, w store.Window) (bool, error) {
+	res, err := s.db.ExecContext(ctx,
+		`INSERT INTO erl_marks AS m (key, bucket_key) VALUES ($1, $2)
+		ON CONFLICT (key) DO UPDATE SET bucket_key = excluded.bucket_key
+		WHERE m.bucket_key <> excluded.bucket_key`,
+		key, w.BucketKey,
+	)
+	if err != nil {
+		return false, fmt.Errorf("erl/store/postgres: mark: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("erl/store/postgres: mark: %w", err)
+	}
+	return n > 0, nil
+}
+
+// Reset removes the counter for the given key.
+func (s *PostgresStore) Reset(ctx context.Context, key string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM erl_counters WHERE key = $1`, key); err != nil {
+		return fmt.Errorf("erl/store/postgres: reset: %w", err)
+	}
+	return nil
+}
+
+// SetOverride creates or replaces the limit override for a resource partition.
+func (s *PostgresStore) SetOverride(ctx context.Context, o store.LimitOverride) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO erl_limit_overrides (resource, partition, limit_value) VALUES ($1, $2, $3)
+		ON CONFLICT (resource, partition) DO UPDATE SET limit_value = excluded.limit_value`,
+		o.Resource, o.Partition, o.Limit,
+	)
+	if err != nil {
+		return fmt.Errorf("erl/store/postgres: set override: %w", err)
+	}
+	return nil
+}
+
+// DeleteOverride removes the limit override for a resource partition.
+func (s *PostgresStore) DeleteOverride(ctx context.Context, resource, partition string) error {
+	_, err := s.db.ExecContext(ctx,
+		`DELETE FROM erl_limit_overrides WHERE resource = $1 AND partition = $2`, resource, partition,
+	)
+	if err != nil {
+		return fmt.Errorf("erl/store/postgres: delete override: %w", err)
+	}
+	return nil
+}
+
+// Overrides returns every stored limit override.
+func (s *PostgresStore) Overrides(ctx context.Context) ([]store.LimitOverride, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT resource, partition, limit_value FROM erl_limit_overrides ORDER BY resource, partition`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("erl/store/postgres: overrides: %w", err)
+	}
+	defer rows.Close()
+
+	var out []store.LimitOverride
+	for rows.Next() {
+		var o store.LimitOverride
+		if err := rows.Scan(&o.Resource, &o.Partition, &o.Limit); err != nil {
+			return nil, fmt.Errorf("erl/store/postgres: overrides: %w", err)
+		}
+		out = append(out, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("erl/store/postgres: overrides: %w", err)
+	}
+	return out, nil
+}
+
+// Close closes the underlying database handle.
+func (s *PostgresStore) Close() error {
+	return s.db.Close()
+}
@@ -4,87 +4,383 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/ryhazerus/erl/store"
 )
 
-// Compile-time interface check.
-var _ store.Store = (*RedisStore)(nil)
+// Compile-time interface checks.
+var (
+	_ store.Store        = (*RedisStore)(nil)
+	_ store.Batcher      = (*RedisStore)(nil)
+	_ store.Marker       = (*RedisStore)(nil)
+	_ store.BatchGetter  = (*RedisStore)(nil)
+	_ store.HistoryStore = (*RedisStore)(nil)
+	_ store.KeyLister    = (*RedisStore)(nil)
+)
 
 // RedisStore is a Store backed by Redis. Each rate limit key is stored as a
-// Redis hash with fields "count" and "bucket_key". A TTL equal to the window
-// duration is set on each key for automatic expiry.
+// Redis hash with fields "count", "bucket_key" and the bucket's bounds, set
+// to expire at the end of its window bucket with millisecond precision.
 type RedisStore struct {
-	client *redis.Client
+	client    redis.UniversalClient
+	prefix    string
+	hashTag   string
+	tagFunc   func(resource string) string
+	retention time.Duration
+}
+
+// Option configures a RedisStore.
+type Option func(*RedisStore)
+
+// WithKeyPrefix sets the prefix of every key the store writes. The default is
+// "erl:". Use distinct prefixes to share one Redis between several limiters.
+func WithKeyPrefix(prefix string) Option {
+	return func(r *RedisStore) {
+		r.prefix = prefix
+	}
+}
+
+// WithHashTag places every key in the Redis Cluster hash tag {tag}, so the
+// counters of a resource and its quota groups, which IncrementMany updates
+// in one script, always share a slot. It is enabled with the tag "erl" for
+// cluster and ring clients; pass an empty tag to disable it, e.g. when no
+// resource uses groups, stacked budgets or shadow limits.
+//
+// A single tag puts every erl key in one slot, and so on one shard. Use
+// WithHashTagFunc to spread resources across the cluster.
+func WithHashTag(tag string) Option {
+	return func(r *RedisStore) {
+		r.hashTag = tag
+		r.tagFunc = nil
+	}
+}
+
+// WithHashTagFunc tags each key with fn applied to the name of the resource
+// it belongs to, so unrelated resources can live on different shards. The
+// counters, budgets, shadow limits and history of one resource always share
+// a tag; fn must also return the same tag for a resource and every quota
+// group it draws from, directly or through other groups, typically the name
+// of the outermost group. An empty tag means the resource's own name. It
+// replaces WithHashTag.
+func WithHashTagFunc(fn func(resource string) string) Option {
+	return func(r *RedisStore) {
+		r.tagFunc = fn
+	}
 }
 
-// NewRedisStore creates a new Redis-backed store.
-func NewRedisStore(client *redis.Client) *RedisStore {
-	return &RedisStore{client: client}
+// WithHistory archives the final count of each window bucket when a counter
+// rolls over, in a sorted set "<counter key>#history", and keeps archived
+// buckets for retention after they start. Counters then expire retention
+// after their bucket ends rather than when it ends. History is off by
+// default. On a Cluster client it needs the hash tag, which keeps a counter
+// and its history in one slot.
+func WithHistory(retention time.Duration) Option {
+	return func(r *RedisStore) {
+		r.retention = retention
+	}
 }
 
-// incrementScript atomically increments a counter, resetting it when the
-// bucket key changes. Returns the new count.
+// NewRedisStore creates a new Redis-backed store. client may be a single
+// node, Sentinel failover, Cluster or Ring client.
+//
+// Cluster and Ring clients get the hash tag "erl" by default, which keeps
+// every counter one request updates in one slot but also puts all erl keys
+// on a single shard. Limiters with many busy resources should spread them
+// with WithHashTagFunc.
+func NewRedisStore(client redis.UniversalClient, opts ...Option) *RedisStore {
+	r := &RedisStore{client: client, prefix: "erl:"}
+	switch client.(type) {
+	case *redis.ClusterClient, *redis.Ring:
+		r.hashTag = "erl"
+	}
+	for _, o := range opts {
+		o(r)
+	}
+	return r
+}
+
+// incrementManyScript applies several increments atomically, resetting each
+// counter whose bucket key has changed and expiring it when its bucket ends.
+// With history on, a closed bucket is first archived in the counter's history
+// sorted set, scored by its start, and counters outlive their bucket by the
+// retention period so an idle counter can still be archived. Returns the new
+// counts in order.
+//
+// ARGV[1] is the history retention in milliseconds, or 0 for no history, and
+// ARGV[2] the Unix time in milliseconds before which archived buckets are
+// pruned. Without history, KEYS[i] is the counter key of the i-th increment;
+// with history, KEYS[2i-1] is the counter key and KEYS[2i] its history key.
+// Each increment then takes four arguments:
+//
+//	ARGV[4i-1] = bucket_key
+//	ARGV[4i]   = bucket start as a Unix time in milliseconds, or 0
+//	ARGV[4i+1] = bucket end as a Unix time in milliseconds, or 0 for no expiry
+//	ARGV[4i+2] = delta
+var incrementManyScript = redis.NewScript(`
+local retention = tonumber(ARGV[1])
+local cutoff = ARGV[2]
+local stride = 1
+if retention > 0 then
+    stride = 2
+end
+
+local out = {}
+for i = 1, #KEYS / stride do
+    local key = KEYS[stride*(i-1)+1]
+    local bucket_key = ARGV[4*i-1]
+    local bucket_end = tonumber(ARGV[4*i+1])
+    local delta = tonumber(ARGV[4*i+2])
+
+    local prev = redis.call("HMGET", key, "count", "bucket_key", "bucket_start", "bucket_end")
+    if prev[2] ~= bucket_key then
+        if retention > 0 and prev[2] and tonumber(prev[3] or "0") > 0 and tonumber(prev[1]) > 0 then
+            local history = KEYS[2*i]
+            redis.call("ZREMRANGEBYSCORE", history, prev[3], prev[3])
+            redis.call("ZADD", history, prev[3], prev[3] .. " " .. prev[4] .. " " .. prev[1] .. " " .. prev[2])
+            redis.call("ZREMRANGEBYSCORE", history, "-inf", "(" .. cutoff)
+            redis.call("PEXPIREAT", history, tonumber(prev[4]) + retention)
+        end
+        redis.call("HSET", key, "count", delta, "bucket_key", bucket_key,
+            "bucket_start", ARGV[4*i], "bucket_end", ARGV[4*i+1])
+        out[i] = delta
+    else
+        out[i] = redis.call("HINCRBY", key, "count", delta)
+    end
+    if bucket_end > 0 then
+        redis.call("PEXPIREAT", key, bucket_end + retention)
+    end
+end
+return out
+`)
+
+// getScript reads a counter, returning 0 when its bucket key has changed.
 //
 // KEYS[1] = counter key
 // ARGV[1] = bucket_key
-// ARGV[2] = window duration in seconds (for TTL)
-var incrementScript = redis.NewScript(`
-local key = KEYS[1]
-local bucket_key = ARGV[1]
-local ttl = tonumber(ARGV[2])
-
-local current_bucket = redis.call("HGET", key, "bucket_key")
-if current_bucket ~= bucket_key then
-    redis.call("HSET", key, "count", "1", "bucket_key", bucket_key)
-    if ttl > 0 then
-        redis.call("EXPIRE", key, ttl)
-    end
-    return 1
+var getScript = redis.NewScript(`
+local v = redis.call("HMGET", KEYS[1], "count", "bucket_key")
+if v[2] ~= ARGV[1] then
+    return 0
 end
+return tonumber(v[1])
+`)
 
-local count = redis.call("HINCRBY", key, "count", 1)
-return count
+// markScript creates a mark unless it exists, expiring it when its bucket
+// ends. Returns 1 if it created the mark. SET's PXAT option would do the same
+// in one command but needs Redis 6.2.
+//
+// KEYS[1] = mark key
+// ARGV[1] = bucket end as a Unix time in milliseconds, or 0 for no expiry
+var markScript = redis.NewScript(`
+if not redis.call("SET", KEYS[1], 1, "NX") then
+    return 0
+end
+local bucket_end = tonumber(ARGV[1])
+if bucket_end > 0 then
+    redis.call("PEXPIREAT", KEYS[1], bucket_end)
+end
+return 1
 `)
 
 // Increment atomically increments the counter for the given key in the current
 // window bucket. If the bucket has rolled over, the counter resets.
 func (r *RedisStore) Increment(ctx context.Context, key string, w store.Window) (int64, error) {
-	ttl := int64(w.Duration.Seconds())
-	result, err := incrementScript.Run(ctx, r.client, []string{redisKey(key)}, w.BucketKey, ttl).Int64()
+	counts, err := r.incrementMany(ctx, []store.Increment{{Key: key, Window: w, Delta: 1}})
 	if err != nil {
 		return 0, fmt.Errorf("erl/store/redis: increment: %w", err)
 	}
-	return result, nil
+	return counts[0], nil
+}
+
+// IncrementMany applies all increments in a single Lua script, so either every
+// counter is updated or none is.
+func (r *RedisStore) IncrementMany(ctx context.Context, incs []store.Increment) ([]int64, error) {
+	counts, err := r.incrementMany(ctx, incs)
+	if err != nil {
+		return nil, fmt.Errorf("erl/store/redis: increment many: %w", err)
+	}
+	return counts, nil
+}
+
+func (r *RedisStore) incrementMany(ctx context.Context, incs []store.Increment) ([]int64, error) {
+	retention := r.retention.Milliseconds()
+	keys := make([]string, 0, 2*len(incs))
+	args := make([]interface{}, 0, 2+4*len(incs))
+	args = append(args, retention, time.Now().Add(-r.retention).UnixMilli())
+	for _, inc := range incs {
+		keys = append(keys, r.key(inc.Key))
+		if retention > 0 {
+			keys = append(keys, r.historyKey(inc.Key))
+		}
+		start, end := bucketBounds(inc.Window)
+		args = append(args, inc.Window.BucketKey, start, end, inc.Delta)
+	}
+	return incrementManyScript.Run(ctx, r.client, keys, args...).Int64Slice()
 }
 
 // Get returns the current counter value for key in the active window bucket.
 func (r *RedisStore) Get(ctx context.Context, key string, w store.Window) (int64, error) {
-	vals, err := r.client.HGetAll(ctx, redisKey(key)).Result()
+	count, err := getScript.Run(ctx, r.client, []string{r.key(key)}, w.BucketKey).Int64()
 	if err != nil {
 		return 0, fmt.Errorf("erl/store/redis: get: %w", err)
 	}
+	return count, nil
+}
+
+// GetMany reads every counter in one pipeline of EVALSHA calls. The script is
+// loaded and the pipeline resent if Redis does not have it cached yet. Each
+// counter is read atomically, but the reads are not one snapshot: counters
+// may change between them.
+func (r *RedisStore) GetMany(ctx context.Context, reads []store.Read) ([]int64, error) {
+	cmds, err := r.getMany(ctx, reads)
+	if redis.HasErrorPrefix(err, "NOSCRIPT") {
+		if err := getScript.Load(ctx, r.client).Err(); err != nil {
+			return nil, fmt.Errorf("erl/store/redis: get many: %w", err)
+		}
+		cmds, err = r.getMany(ctx, reads)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erl/store/redis: get many: %w", err)
+	}
 
-	if len(vals) == 0 {
-		return 0, nil
+	out := make([]int64, len(cmds))
+	for i, cmd := range cmds {
+		n, err := cmd.Int64()
+		if err != nil && err != redis.Nil {
+			return nil, fmt.Errorf("erl/store/redis: get many: %w", err)
+		}
+		out[i] = n
 	}
+	return out, nil
+}
 
-	if vals["bucket_key"] != w.BucketKey {
-		return 0, nil
+func (r *RedisStore) getMany(ctx context.Context, reads []store.Read) ([]*redis.Cmd, error) {
+	pipe := r.client.Pipeline()
+	cmds := make([]*redis.Cmd, len(reads))
+	for i, rd := range reads {
+		cmds[i] = getScript.EvalSha(ctx, pipe, []string{r.key(rd.Key)}, rd.Window.BucketKey)
 	}
+	if len(cmds) == 0 {
+		return cmds, nil
+	}
+	_, err := pipe.Exec(ctx)
+	if err == redis.Nil {
+		// A missing count reads as zero; GetMany checks each command.
+		err = nil
+	}
+	return cmds, err
+}
 
-	count, err := strconv.ParseInt(vals["count"], 10, 64)
+// Mark records key in the window bucket with SET NX, expiring it when the
+// bucket ends, and reports whether this call created it.
+func (r *RedisStore) Mark(ctx context.Context, key string, w store.Window) (bool, error) {
+	_, end := bucketBounds(w)
+	created, err := markScript.Run(ctx, r.client, []string{r.key(key) + ":" + w.BucketKey}, end).Int64()
 	if err != nil {
-		return 0, fmt.Errorf("erl/store/redis: parse count: %w", err)
+		return false, fmt.Errorf("erl/store/redis: mark: %w", err)
 	}
+	return created == 1, nil
+}
 
-	return count, nil
+// History returns the archived buckets of key that started in [from, to),
+// followed by the counter's current bucket if it started in that range.
+func (r *RedisStore) History(ctx context.Context, key string, from, to time.Time) ([]store.Bucket, error) {
+	pipe := r.client.Pipeline()
+	archived := pipe.ZRangeByScore(ctx, r.historyKey(key), &redis.ZRangeBy{
+		Min: strconv.FormatInt(from.UnixMilli(), 10),
+		Max: "(" + strconv.FormatInt(to.UnixMilli(), 10),
+	})
+	current := pipe.HMGet(ctx, r.key(key), "count", "bucket_key", "bucket_start", "bucket_end")
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("erl/store/redis: history: %w", err)
+	}
+
+	var out []store.Bucket
+	for _, m := range archived.Val() {
+		// Members are "<start> <end> <count> <bucket key>".
+		f := strings.SplitN(m, " ", 4)
+		if len(f) != 4 {
+			continue
+		}
+		out = append(out, bucket(f[3], f[0], f[1], f[2]))
+	}
+
+	v := current.Val()
+	if len(v) == 4 && v[0] != nil && v[1] != nil && v[2] != nil && v[3] != nil {
+		b := bucket(v[1].(string), v[2].(string), v[3].(string), v[0].(string))
+		if !b.Start.IsZero() && !b.Start.Before(from) && b.Start.Before(to) {
+			out = append(out, b)
+		}
+	}
+	return out, nil
+}
+
+// Keys returns the keys starting with prefix that have a counter or archived
+// buckets, found with SCAN on every shard. Mark keys are listed too, as
+// "<key>:<bucket key>".
+func (r *RedisStore) Keys(ctx context.Context, prefix string) ([]string, error) {
+	match := globEscaper.Replace(r.key(prefix)) + "*"
+	if r.tagFunc != nil {
+		// Keys under prefix may carry any tag.
+		match = globEscaper.Replace(r.prefix) + "{*}:" + globEscaper.Replace(prefix) + "*"
+	}
+
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	scan := func(ctx context.Context, c redis.UniversalClient) error {
+		iter := c.Scan(ctx, 0, match, 1000).Iterator()
+		for iter.Next(ctx) {
+			key := strings.TrimSuffix(r.storeKey(iter.Val()), "#history")
+			mu.Lock()
+			seen[key] = true
+			mu.Unlock()
+		}
+		return iter.Err()
+	}
+
+	var err error
+	switch c := r.client.(type) {
+	case *redis.ClusterClient:
+		err = c.ForEachMaster(ctx, func(ctx context.Context, n *redis.Client) error { return scan(ctx, n) })
+	case *redis.Ring:
+		err = c.ForEachShard(ctx, func(ctx context.Context, n *redis.Client) error { return scan(ctx, n) })
+	default:
+		err = scan(ctx, r.client)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erl/store/redis: keys: %w", err)
+	}
+
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// globEscaper escapes the characters SCAN MATCH treats as wildcards.
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// bucket parses a bucket's fields as stored in Redis.
+func bucket(key, start, end, count string) store.Bucket {
+	b := store.Bucket{Key: key}
+	if ms, _ := strconv.ParseInt(start, 10, 64); ms > 0 {
+		b.Start = time.UnixMilli(ms)
+	}
+	if ms, _ := strconv.ParseInt(end, 10, 64); ms > 0 {
+		b.End = time.UnixMilli(ms)
+	}
+	b.Count, _ = strconv.ParseInt(count, 10, 64)
+	return b
 }
 
 // Reset removes the counter for the given key.
 func (r *RedisStore) Reset(ctx context.Context, key string) error {
-	return r.client.Del(ctx, redisKey(key)).Err()
+	return r.client.Del(ctx, r.key(key)).Err()
 }
 
 // Close closes the underlying Redis client.
@@ -92,6 +388,61 @@ func (r *RedisStore) Close() error {
 	return r.client.Close()
 }
 
-func redisKey(key string) string {
-	return "erl:" + key
+// key returns the Redis key for a store key.
+func (r *RedisStore) key(key string) string {
+	tag := r.hashTag
+	if r.tagFunc != nil {
+		name := resourceName(key)
+		if tag = r.tagFunc(name); tag == "" {
+			tag = name
+		}
+	}
+	if tag == "" {
+		return r.prefix + key
+	}
+	return r.prefix + "{" + tag + "}:" + key
+}
+
+// storeKey returns the store key of a Redis key written by key.
+func (r *RedisStore) storeKey(key string) string {
+	key = key[len(r.prefix):]
+	switch {
+	case r.tagFunc != nil:
+		_, key, _ = strings.Cut(key, "}:")
+	case r.hashTag != "":
+		key = key[len(r.hashTag)+3:]
+	}
+	return key
+}
+
+// nameUnescaper reverses the escaping of resource names in the limiter's
+// counter keys.
+var nameUnescaper = strings.NewReplacer("%25", "%", "%3A", ":", "%23", "#")
+
+// resourceName returns the name of the resource a limiter key belongs to:
+// the part before the partition (":") or a derived counter's suffix ("#").
+func resourceName(key string) string {
+	if i := strings.IndexAny(key, ":#"); i >= 0 {
+		key = key[:i]
+	}
+	return nameUnescaper.Replace(key)
+}
+
+// historyKey returns the Redis key of a counter's archived buckets. It shares
+// the counter's hash tag, so the script archiving a bucket touches one slot.
+func (r *RedisStore) historyKey(key string) string {
+	return r.key(key) + "#history"
+}
+
+// bucketBounds returns when w's bucket starts and ends as Unix times in
+// milliseconds. Windows without a start are assumed to begin now, and are
+// recorded with a zero start; windows without a duration never end.
+func bucketBounds(w store.Window) (start, end int64) {
+	if w.Duration <= 0 {
+		return 0, 0
+	}
+	if w.BucketStart.IsZero() {
+		return 0, time.Now().Add(w.Duration).UnixMilli()
+	}
+	return w.BucketStart.UnixMilli(), w.BucketStart.Add(w.Duration).UnixMilli()
 }
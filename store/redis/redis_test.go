@@ -2,6 +2,7 @@ package redis
 
 import (
 	"context"
+	"slices"
 	"testing"
 	"time"
 
@@ -10,9 +11,18 @@ import (
 	"github.com/ryhazerus/erl/store"
 )
 
-func newTestRedisStore(t *testing.T) *RedisStore {
+// newMiniredis starts a server whose clock is inside the 14:30 bucket the
+// tests use, so counters expire relative to it rather than the real time.
+func newMiniredis(t *testing.T) *miniredis.Miniredis {
 	t.Helper()
 	mr := miniredis.RunT(t)
+	mr.SetTime(time.Date(2024, 1, 15, 14, 30, 15, 0, time.UTC))
+	return mr
+}
+
+func newTestRedisStore(t *testing.T) *RedisStore {
+	t.Helper()
+	mr := newMiniredis(t)
 	client := goredis.NewClient(&goredis.Options{Addr: mr.Addr()})
 	t.Cleanup(func() { client.Close() })
 	return NewRedisStore(client)
@@ -102,3 +112,401 @@ func TestRedisStoreReset(t *testing.T) {
 		t.Errorf("after reset: got %d, want 0", got)
 	}
 }
+
+func TestRedisStoreIncrementMany(t *testing.T) {
+	s := newTestRedisStore(t)
+	ctx := context.Background()
+	w := store.Window{
+		Duration:    time.Minute,
+		BucketKey:   "2024-01-15T14:30",
+		BucketStart: time.Date(2024, 1, 15, 14, 30, 0, 0, time.UTC),
+	}
+
+	s.Increment(ctx, "child", w)
+
+	got, err := s.IncrementMany(ctx, []store.Increment{
+		{Key: "child", Window: w, Delta: 1},
+		{Key: "parent", Window: w, Delta: 5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0] != 2 || got[1] != 5 {
+		t.Errorf("counts = %v, want [2 5]", got)
+	}
+}
+
+func TestRedisStoreMark(t *testing.T) {
+	s := newTestRedisStore(t)
+	ctx := context.Background()
+	w := store.Window{
+		Duration:    time.Minute,
+		BucketKey:   "2024-01-15T14:30",
+		BucketStart: time.Date(2024, 1, 15, 14, 30, 0, 0, time.UTC),
+	}
+
+	if first, err := s.Mark(ctx, "alert", w); err != nil || !first {
+		t.Fatalf("first mark = %v, %v; want true", first, err)
+	}
+	if first, _ := s.Mark(ctx, "alert", w); first {
+		t.Error("second mark in the same bucket reported first")
+	}
+}
+
+func TestRedisStoreGetManyReturnsErrors(t *testing.T) {
+	mr := newMiniredis(t)
+	s := NewRedisStore(goredis.NewClient(&goredis.Options{Addr: mr.Addr()}))
+	t.Cleanup(func() { s.Close() })
+	w := minute(time.Now())
+
+	// A plain string where a counter hash is expected fails with WRONGTYPE.
+	mr.Set("erl:broken", "1")
+	if _, err := s.GetMany(context.Background(), []store.Read{{Key: "ok", Window: w}, {Key: "broken", Window: w}}); err == nil {
+		t.Error("GetMany succeeded on a key of the wrong type")
+	}
+}
+
+func TestRedisStoreKeyPrefix(t *testing.T) {
+	mr := newMiniredis(t)
+	client := goredis.NewClient(&goredis.Options{Addr: mr.Addr()})
+	s := NewRedisStore(client, WithKeyPrefix("billing:limits:"))
+	t.Cleanup(func() { s.Close() })
+	ctx := context.Background()
+	w := store.Window{
+		Duration:    time.Minute,
+		BucketKey:   "2024-01-15T14:30",
+		BucketStart: time.Date(2024, 1, 15, 14, 30, 0, 0, time.UTC),
+	}
+
+	s.Increment(ctx, "stripe", w)
+	if !mr.Exists("billing:limits:stripe") {
+		t.Errorf("keys = %v, want billing:limits:stripe", mr.Keys())
+	}
+}
+
+func TestRedisStoreCluster(t *testing.T) {
+	mr := newMiniredis(t)
+	client := goredis.NewClusterClient(&goredis.ClusterOptions{Addrs: []string{mr.Addr()}})
+	s := NewRedisStore(client)
+	t.Cleanup(func() { s.Close() })
+	ctx := context.Background()
+	w := store.Window{
+		Duration:    time.Minute,
+		BucketKey:   "2024-01-15T14:30",
+		BucketStart: time.Date(2024, 1, 15, 14, 30, 0, 0, time.UTC),
+	}
+
+	got, err := s.IncrementMany(ctx, []store.Increment{
+		{Key: "openai:acme", Window: w, Delta: 1},
+		{Key: "ai-vendors", Window: w, Delta: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0] != 1 || got[1] != 1 {
+		t.Errorf("counts = %v, want [1 1]", got)
+	}
+
+	// Both keys carry the same hash tag, so they share a slot.
+	for _, k := range []string{"erl:{erl}:openai:acme", "erl:{erl}:ai-vendors"} {
+		if !mr.Exists(k) {
+			t.Errorf("missing key %q in %v", k, mr.Keys())
+		}
+	}
+
+	if n, err := s.Get(ctx, "openai:acme", w); err != nil || n != 1 {
+		t.Errorf("get = %d, %v; want 1", n, err)
+	}
+	counts, err := s.GetMany(ctx, []store.Read{{Key: "openai:acme", Window: w}, {Key: "ai-vendors", Window: w}})
+	if err != nil || counts[0] != 1 || counts[1] != 1 {
+		t.Errorf("get many = %v, %v; want [1 1]", counts, err)
+	}
+}
+
+func TestRedisStoreHashTagOption(t *testing.T) {
+	mr := newMiniredis(t)
+	client := goredis.NewUniversalClient(&goredis.UniversalOptions{Addrs: []string{mr.Addr()}})
+	s := NewRedisStore(client, WithHashTag("tenant-limits"))
+	t.Cleanup(func() { s.Close() })
+
+	if got, want := s.key("stripe"), "erl:{tenant-limits}:stripe"; got != want {
+		t.Errorf("key = %q, want %q", got, want)
+	}
+
+	cluster := NewRedisStore(goredis.NewClusterClient(&goredis.ClusterOptions{Addrs: []string{mr.Addr()}}), WithHashTag(""))
+	t.Cleanup(func() { cluster.Close() })
+	if got, want := cluster.key("stripe"), "erl:stripe"; got != want {
+		t.Errorf("untagged cluster key = %q, want %q", got, want)
+	}
+}
+
+func TestRedisStoreHashTagFunc(t *testing.T) {
+	mr := newMiniredis(t)
+	client := goredis.NewClusterClient(&goredis.ClusterOptions{Addrs: []string{mr.Addr()}})
+	groups := map[string]string{"openai": "ai-vendors", "anthropic": "ai-vendors"}
+	s := NewRedisStore(client, WithHistory(time.Hour), WithHashTagFunc(func(resource string) string {
+		return groups[resource]
+	}))
+	t.Cleanup(func() { s.Close() })
+	ctx := context.Background()
+	w := minute(time.Now())
+
+	if _, err := s.IncrementMany(ctx, []store.Increment{
+		{Key: "openai:acme", Window: w, Delta: 1},
+		{Key: "openai:acme#spend", Window: w, Delta: 250},
+		{Key: "ai-vendors", Window: w, Delta: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Increment(ctx, "stripe%3Aeu:acme", w); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []string{
+		"erl:{ai-vendors}:openai:acme",
+		"erl:{ai-vendors}:openai:acme#spend",
+		"erl:{ai-vendors}:ai-vendors",
+		"erl:{stripe:eu}:stripe%3Aeu:acme",
+	} {
+		if !mr.Exists(k) {
+			t.Errorf("missing key %q in %v", k, mr.Keys())
+		}
+	}
+
+	got, err := s.Keys(ctx, "openai:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(got)
+	if want := []string{"openai:acme", "openai:acme#spend"}; !slices.Equal(got, want) {
+		t.Errorf("keys = %v, want %v", got, want)
+	}
+}
+
+func TestRedisStoreExpiresAtBucketEnd(t *testing.T) {
+	mr := newMiniredis(t)
+	s := NewRedisStore(goredis.NewClient(&goredis.Options{Addr: mr.Addr()}))
+	t.Cleanup(func() { s.Close() })
+	ctx := context.Background()
+	w := store.Window{
+		Duration:    time.Minute,
+		BucketKey:   "2024-01-15T14:30",
+		BucketStart: time.Date(2024, 1, 15, 14, 30, 0, 0, time.UTC),
+	}
+
+	// The clock is 15s into the bucket, so 45s are left whichever write
+	// set the expiry.
+	s.Increment(ctx, "stripe", w)
+	s.Increment(ctx, "stripe", w)
+	if got := mr.TTL("erl:stripe"); got != 45*time.Second {
+		t.Errorf("counter ttl = %v, want 45s", got)
+	}
+	s.Mark(ctx, "alert", w)
+	if got := mr.TTL("erl:alert:2024-01-15T14:30"); got != 45*time.Second {
+		t.Errorf("mark ttl = %v, want 45s", got)
+	}
+
+	mr.FastForward(45 * time.Second)
+	if mr.Exists("erl:stripe") {
+		t.Error("counter outlived its bucket")
+	}
+}
+
+func TestRedisStoreMillisecondWindow(t *testing.T) {
+	mr := newMiniredis(t)
+	s := NewRedisStore(goredis.NewClient(&goredis.Options{Addr: mr.Addr()}))
+	t.Cleanup(func() { s.Close() })
+	ctx := context.Background()
+	w := store.Window{
+		Duration:    250 * time.Millisecond,
+		BucketKey:   "2024-01-15T14:30:15.000",
+		BucketStart: time.Date(2024, 1, 15, 14, 30, 15, 0, time.UTC),
+	}
+
+	s.IncrementMany(ctx, []store.Increment{{Key: "burst", Window: w, Delta: 3}})
+	if got := mr.TTL("erl:burst"); got != 250*time.Millisecond {
+		t.Errorf("ttl = %v, want 250ms", got)
+	}
+	if n, _ := s.Get(ctx, "burst", w); n != 3 {
+		t.Errorf("get = %d, want 3", n)
+	}
+
+	mr.FastForward(250 * time.Millisecond)
+	if n, _ := s.Get(ctx, "burst", w); n != 0 {
+		t.Errorf("after bucket end: got %d, want 0", n)
+	}
+}
+
+func TestRedisStoreGetMany(t *testing.T) {
+	mr := newMiniredis(t)
+	s := NewRedisStore(goredis.NewClient(&goredis.Options{Addr: mr.Addr()}))
+	t.Cleanup(func() { s.Close() })
+	ctx := context.Background()
+	w1 := store.Window{
+		Duration:    time.Minute,
+		BucketKey:   "2024-01-15T14:30",
+		BucketStart: time.Date(2024, 1, 15, 14, 30, 0, 0, time.UTC),
+	}
+	w2 := store.Window{
+		Duration:    time.Minute,
+		BucketKey:   "2024-01-15T14:31",
+		BucketStart: time.Date(2024, 1, 15, 14, 31, 0, 0, time.UTC),
+	}
+
+	s.Increment(ctx, "stripe", w1)
+	s.Increment(ctx, "stripe", w1)
+	s.IncrementMany(ctx, []store.Increment{{Key: "stripe#spend", Window: w1, Delta: 1500}})
+
+	reads := []store.Read{
+		{Key: "stripe", Window: w1},
+		{Key: "stripe#spend", Window: w1},
+		{Key: "missing", Window: w1},
+		{Key: "stripe", Window: w2},
+	}
+	// The first call finds the script uncached and loads it; the second
+	// reuses it.
+	s.client.ScriptFlush(ctx)
+	for range 2 {
+		got, err := s.GetMany(ctx, reads)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []int64{2, 1500, 0, 0}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("count %d: got %d, want %d", i, got[i], want[i])
+			}
+		}
+	}
+
+	if got, err := s.GetMany(ctx, nil); err != nil || len(got) != 0 {
+		t.Errorf("empty get many = %v, %v", got, err)
+	}
+}
+
+// minute returns the minute bucket starting at t.
+func minute(t time.Time) store.Window {
+	start := t.UTC().Truncate(time.Minute)
+	return store.Window{Duration: time.Minute, BucketKey: start.Format("2006-01-02T15:04"), BucketStart: start}
+}
+
+func TestRedisStoreHistory(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s := NewRedisStore(goredis.NewClient(&goredis.Options{Addr: mr.Addr()}), WithHistory(150*time.Second))
+	t.Cleanup(func() { s.Close() })
+	ctx := context.Background()
+
+	// The recent bucket started at most two minutes ago, inside the
+	// retention period; the old one did not.
+	now := time.Now()
+	old, recent, current := minute(now.Add(-10*time.Minute)), minute(now.Add(-time.Minute)), minute(now)
+	s.Increment(ctx, "stripe", old)
+	s.Increment(ctx, "stripe", recent)
+	s.IncrementMany(ctx, []store.Increment{{Key: "stripe", Window: recent, Delta: 2}})
+	if _, err := s.Increment(ctx, "stripe", current); err != nil {
+		t.Fatal(err)
+	}
+
+	// The old bucket was archived and then pruned as out of retention.
+	got, err := s.History(ctx, "stripe", time.Time{}, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("history = %+v, want 2 buckets", got)
+	}
+	if got[0].Key != recent.BucketKey || got[0].Count != 3 ||
+		!got[0].Start.Equal(recent.BucketStart) || !got[0].End.Equal(recent.BucketStart.Add(time.Minute)) {
+		t.Errorf("archived bucket = %+v, want %s with 3", got[0], recent.BucketKey)
+	}
+	if got[1].Key != current.BucketKey || got[1].Count != 1 {
+		t.Errorf("current bucket = %+v, want %s with 1", got[1], current.BucketKey)
+	}
+
+	got, _ = s.History(ctx, "stripe", recent.BucketStart, current.BucketStart)
+	if len(got) != 1 || got[0].Key != recent.BucketKey {
+		t.Errorf("recent only = %+v", got)
+	}
+
+	// The counter outlives its bucket by the retention period.
+	end := current.BucketStart.Add(time.Minute + 150*time.Second)
+	if ttl := mr.TTL("erl:stripe"); ttl < time.Until(end)-time.Second || ttl > time.Until(end)+time.Second {
+		t.Errorf("counter ttl = %v, want about %v", ttl, time.Until(end))
+	}
+}
+
+func TestRedisStoreHistoryDisabled(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s := NewRedisStore(goredis.NewClient(&goredis.Options{Addr: mr.Addr()}))
+	t.Cleanup(func() { s.Close() })
+	ctx := context.Background()
+
+	now := time.Now()
+	s.Increment(ctx, "stripe", minute(now.Add(-time.Minute)))
+	s.Increment(ctx, "stripe", minute(now))
+
+	got, err := s.History(ctx, "stripe", time.Time{}, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Key != minute(now).BucketKey {
+		t.Errorf("history = %+v, want only the current bucket", got)
+	}
+	if mr.Exists("erl:stripe#history") {
+		t.Error("history key written with history off")
+	}
+	if got, _ := s.History(ctx, "missing", time.Time{}, now); len(got) != 0 {
+		t.Errorf("missing key history = %+v", got)
+	}
+}
+
+func TestRedisStoreClusterHistory(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := goredis.NewClusterClient(&goredis.ClusterOptions{Addrs: []string{mr.Addr()}})
+	s := NewRedisStore(client, WithHistory(time.Hour))
+	t.Cleanup(func() { s.Close() })
+	ctx := context.Background()
+
+	now := time.Now()
+	for _, w := range []store.Window{minute(now.Add(-time.Minute)), minute(now)} {
+		if _, err := s.IncrementMany(ctx, []store.Increment{
+			{Key: "openai:acme", Window: w, Delta: 1},
+			{Key: "ai-vendors", Window: w, Delta: 1},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !mr.Exists("erl:{erl}:ai-vendors#history") {
+		t.Errorf("missing history key in %v", mr.Keys())
+	}
+	if got, _ := s.History(ctx, "ai-vendors", time.Time{}, now.Add(time.Minute)); len(got) != 2 {
+		t.Errorf("history = %+v, want 2 buckets", got)
+	}
+}
+
+func TestRedisStoreKeys(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s := NewRedisStore(goredis.NewClient(&goredis.Options{Addr: mr.Addr()}),
+		WithKeyPrefix("billing[eu]:"), WithHistory(time.Hour))
+	t.Cleanup(func() { s.Close() })
+	ctx := context.Background()
+
+	// Another limiter's keys that the prefix must not match as a glob.
+	mr.Set("billinge:openai:acme", "1")
+
+	now := time.Now()
+	for _, key := range []string{"openai", "openai:acme", "openai-batch"} {
+		s.Increment(ctx, key, minute(now))
+	}
+	s.Increment(ctx, "openai:globex", minute(now.Add(-time.Minute)))
+	s.Increment(ctx, "openai:globex", minute(now))
+
+	got, err := s.Keys(ctx, "openai:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(got)
+	if want := []string{"openai:acme", "openai:globex"}; !slices.Equal(got, want) {
+		t.Errorf("keys = %v, want %v", got, want)
+	}
+}
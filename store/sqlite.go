@@ -4,21 +4,45 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	_ "modernc.org/sqlite"
 )
 
-// Compile-time interface check.
-var _ Store = (*SQLiteStore)(nil)
+// Compile-time interface checks.
+var (
+	_ Store         = (*SQLiteStore)(nil)
+	_ Batcher       = (*SQLiteStore)(nil)
+	_ Marker        = (*SQLiteStore)(nil)
+	_ OverrideStore = (*SQLiteStore)(nil)
+	_ BatchGetter   = (*SQLiteStore)(nil)
+	_ HistoryStore  = (*SQLiteStore)(nil)
+	_ KeyLister     = (*SQLiteStore)(nil)
+)
 
 // SQLiteStore is a persistent Store backed by SQLite.
 type SQLiteStore struct {
-	db *sql.DB
+	db        *sql.DB
+	retention time.Duration
+}
+
+// SQLiteOption configures a SQLiteStore.
+type SQLiteOption func(*SQLiteStore)
+
+// WithHistory archives the final count of each window bucket in the
+// erl_history table when a counter rolls over, and keeps archived buckets
+// for retention after they start. History is off by default.
+func WithHistory(retention time.Duration) SQLiteOption {
+	return func(s *SQLiteStore) {
+		s.retention = retention
+	}
 }
 
 // NewSQLiteStore opens (or creates) a SQLite database at the given path and
 // initialises the schema. Use ":memory:" for an in-memory SQLite database.
-func NewSQLiteStore(dsn string) (*SQLiteStore, error) {
+func NewSQLiteStore(dsn string, opts ...SQLiteOption) (*SQLiteStore, error) {
 	db, err := sql.Open("sqlite", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("erl/store: open sqlite: %w", err)
@@ -36,55 +60,232 @@ func NewSQLiteStore(dsn string) (*SQLiteStore, error) {
 		return nil, fmt.Errorf("erl/store: create table: %w", err)
 	}
 
-	return &SQLiteStore{db: db}, nil
+	// Bucket bounds, in Unix milliseconds, were added for history; older
+	// databases gain the columns here.
+	for _, col := range []string{"bucket_start", "bucket_end"} {
+		if err := addColumn(db, "erl_counters", col, "INTEGER NOT NULL DEFAULT 0"); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("erl/store: add column %s: %w", col, err)
+		}
+	}
+
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS erl_history (
+			key          TEXT NOT NULL,
+			bucket_key   TEXT NOT NULL,
+			bucket_start INTEGER NOT NULL,
+			bucket_end   INTEGER NOT NULL,
+			count        INTEGER NOT NULL,
+			PRIMARY KEY (key, bucket_start)
+		)
+	`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("erl/store: create history table: %w", err)
+	}
+
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS erl_limit_overrides (
+			resource    TEXT NOT NULL,
+			partition   TEXT NOT NULL DEFAULT '',
+			limit_value INTEGER NOT NULL,
+			PRIMARY KEY (resource, partition)
+		)
+	`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("erl/store: create overrides table: %w", err)
+	}
+
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS erl_marks (
+			key        TEXT PRIMARY KEY,
+			bucket_key TEXT NOT NULL
+		)
+	`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("erl/store: create marks table: %w", err)
+	}
+
+	s := &SQLiteStore{db: db}
+	for _, o := range opts {
+		o(s)
+	}
+	return s, nil
+}
+
+// addColumn adds a column to table unless it already exists.
+func addColumn(db *sql.DB, table, column, def string) error {
+	var n int
+	if err := db.QueryRow(
+		`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column,
+	).Scan(&n); err != nil || n > 0 {
+		return err
+	}
+	_, err := db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + def)
+	return err
 }
 
 // Increment atomically adds one to the counter for key in the current window bucket.
 // If the bucket has rolled over, the counter is reset before incrementing.
 func (s *SQLiteStore) Increment(ctx context.Context, key string, w Window) (int64, error) {
-	tx, err := s.db.BeginTx(ctx, nil)
+	counts, err := s.IncrementMany(ctx, []Increment{{Key: key, Window: w, Delta: 1}})
 	if err != nil {
 		return 0, err
 	}
+	return counts[0], nil
+}
+
+// IncrementMany applies all increments in a single transaction.
+func (s *SQLiteStore) IncrementMany(ctx context.Context, incs []Increment) ([]int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
 	defer tx.Rollback()
 
-	var count int64
+	out := make([]int64, len(incs))
+	for i, inc := range incs {
+		if out[i], err = s.add(ctx, tx, inc); err != nil {
+			return nil, err
+		}
+	}
+
+	return out, tx.Commit()
+}
+
+// add applies a single increment within tx.
+func (s *SQLiteStore) add(ctx context.Context, tx *sql.Tx, inc Increment) (int64, error) {
+	var count, bucketStart, bucketEnd int64
 	var bucketKey string
+	start, end := bucketBounds(inc.Window)
 
-	err = tx.QueryRowContext(ctx,
-		`SELECT count, bucket_key FROM erl_counters WHERE key = ?`, key,
-	).Scan(&count, &bucketKey)
+	err := tx.QueryRowContext(ctx,
+		`SELECT count, bucket_key, bucket_start, bucket_end FROM erl_counters WHERE key = ?`, inc.Key,
+	).Scan(&count, &bucketKey, &bucketStart, &bucketEnd)
 
 	if err == sql.ErrNoRows {
 		// New key, insert.
 		_, err = tx.ExecContext(ctx,
-			`INSERT INTO erl_counters (key, count, bucket_key, window_seconds) VALUES (?, 1, ?, ?)`,
-			key, w.BucketKey, int64(w.Duration.Seconds()),
+			`INSERT INTO erl_counters (key, count, bucket_key, window_seconds, bucket_start, bucket_end)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			inc.Key, inc.Delta, inc.Window.BucketKey, int64(inc.Window.Duration.Seconds()), start, end,
 		)
 		if err != nil {
 			return 0, err
 		}
-		return 1, tx.Commit()
+		return inc.Delta, nil
 	}
 	if err != nil {
 		return 0, err
 	}
 
-	if bucketKey != w.BucketKey {
-		// Window rolled over, reset.
+	if bucketKey != inc.Window.BucketKey {
+		// Window rolled over, archive the closed bucket and reset.
+		if s.retention > 0 && count > 0 && bucketStart > 0 {
+			if err := s.archive(ctx, tx, inc.Key, Bucket{
+				Key:   bucketKey,
+				Start: time.UnixMilli(bucketStart),
+				End:   time.UnixMilli(bucketEnd),
+				Count: count,
+			}); err != nil {
+				return 0, err
+			}
+		}
 		count = 0
 	}
 
-	count++
+	count += inc.Delta
 	_, err = tx.ExecContext(ctx,
-		`UPDATE erl_counters SET count = ?, bucket_key = ?, window_seconds = ? WHERE key = ?`,
-		count, w.BucketKey, int64(w.Duration.Seconds()), key,
+		`UPDATE erl_counters SET count = ?, bucket_key = ?, window_seconds = ?, bucket_start = ?, bucket_end = ?
+		WHERE key = ?`,
+		count, inc.Window.BucketKey, int64(inc.Window.Duration.Seconds()), start, end, inc.Key,
 	)
 	if err != nil {
 		return 0, err
 	}
 
-	return count, tx.Commit()
+	return count, nil
+}
+
+// archive records a closed bucket of key within tx and drops the buckets
+// that fell out of the retention period.
+func (s *SQLiteStore) archive(ctx context.Context, tx *sql.Tx, key string, b Bucket) error {
+	if _, err := tx.ExecContext(ctx,
+		`INSERT OR REPLACE INTO erl_history (key, bucket_key, bucket_start, bucket_end, count) VALUES (?, ?, ?, ?, ?)`,
+		key, b.Key, b.Start.UnixMilli(), b.End.UnixMilli(), b.Count,
+	); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx,
+		`DELETE FROM erl_history WHERE key = ? AND bucket_start < ?`,
+		key, time.Now().Add(-s.retention).UnixMilli(),
+	)
+	return err
+}
+
+// History returns the archived buckets of key that started in [from, to),
+// followed by the counter's current bucket if it started in that range.
+func (s *SQLiteStore) History(ctx context.Context, key string, from, to time.Time) ([]Bucket, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT bucket_key, bucket_start, bucket_end, count FROM erl_history
+		WHERE key = ? AND bucket_start >= ? AND bucket_start < ?
+		UNION ALL
+		SELECT bucket_key, bucket_start, bucket_end, count FROM erl_counters
+		WHERE key = ? AND bucket_start > 0 AND bucket_start >= ? AND bucket_start < ?
+		ORDER BY bucket_start`,
+		key, from.UnixMilli(), to.UnixMilli(),
+		key, from.UnixMilli(), to.UnixMilli(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Bucket
+	for rows.Next() {
+		var b Bucket
+		var start, end int64
+		if err := rows.Scan(&b.Key, &start, &end, &b.Count); err != nil {
+			return nil, err
+		}
+		b.Start, b.End = time.UnixMilli(start), time.UnixMilli(end)
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+// Keys returns the keys starting with prefix that have a counter or archived
+// buckets.
+func (s *SQLiteStore) Keys(ctx context.Context, prefix string) ([]string, error) {
+	n := utf8.RuneCountInString(prefix)
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT key FROM erl_counters WHERE substr(key, 1, ?) = ?
+		UNION
+		SELECT key FROM erl_history WHERE substr(key, 1, ?) = ?`,
+		n, prefix, n, prefix,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// bucketBounds returns when w's bucket starts and ends in Unix milliseconds,
+// or zeros for a window without a start.
+func bucketBounds(w Window) (start, end int64) {
+	if w.BucketStart.IsZero() {
+		return 0, 0
+	}
+	return w.BucketStart.UnixMilli(), w.BucketStart.Add(w.Duration).UnixMilli()
 }
 
 // Get returns the current counter value for key in the active window bucket.
@@ -110,12 +311,125 @@ func (s *SQLiteStore) Get(ctx context.Context, key string, w Window) (int64, err
 	return count, nil
 }
 
+// getManyChunk caps the keys read by one query, well under SQLite's limit on
+// bound parameters.
+const getManyChunk = 500
+
+// GetMany reads the counters with one SELECT ... WHERE key IN (...) per
+// getManyChunk keys.
+func (s *SQLiteStore) GetMany(ctx context.Context, reads []Read) ([]int64, error) {
+	rows := make(map[string]counterRow, len(reads))
+	for start := 0; start < len(reads); start += getManyChunk {
+		if err := s.getChunk(ctx, reads[start:min(start+getManyChunk, len(reads))], rows); err != nil {
+			return nil, err
+		}
+	}
+
+	out := make([]int64, len(reads))
+	for i, rd := range reads {
+		if r, ok := rows[rd.Key]; ok && r.bucketKey == rd.Window.BucketKey {
+			out[i] = r.count
+		}
+	}
+	return out, nil
+}
+
+// counterRow is a row of erl_counters read by GetMany.
+type counterRow struct {
+	count     int64
+	bucketKey string
+}
+
+// getChunk reads the rows of the keys in reads into rows.
+func (s *SQLiteStore) getChunk(ctx context.Context, reads []Read, rows map[string]counterRow) error {
+	args := make([]any, len(reads))
+	for i, rd := range reads {
+		args[i] = rd.Key
+	}
+
+	rs, err := s.db.QueryContext(ctx,
+		`SELECT key, count, bucket_key FROM erl_counters WHERE key IN (?`+strings.Repeat(", ?", len(reads)-1)+`)`,
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rs.Close()
+
+	for rs.Next() {
+		var key string
+		var r counterRow
+		if err := rs.Scan(&key, &r.count, &r.bucketKey); err != nil {
+			return err
+		}
+		rows[key] = r
+	}
+	return rs.Err()
+}
+
+// Mark records key in the window bucket and reports whether it was new.
+func (s *SQLiteStore) Mark(ctx context.Context, key string, w Window) (bool, error) {
+	res, err := s.db.ExecContext(ctx,
+		`INSERT INTO erl_marks (key, bucket_key) VALUES (?, ?)
+		ON CONFLICT (key) DO UPDATE SET bucket_key = excluded.bucket_key
+		WHERE bucket_key != excluded.bucket_key`,
+		key, w.BucketKey,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
 // Reset removes the counter for the given key.
 func (s *SQLiteStore) Reset(ctx context.Context, key string) error {
 	_, err := s.db.ExecContext(ctx, `DELETE FROM erl_counters WHERE key = ?`, key)
 	return err
 }
 
+// SetOverride creates or replaces the limit override for a resource partition.
+func (s *SQLiteStore) SetOverride(ctx context.Context, o LimitOverride) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO erl_limit_overrides (resource, partition, limit_value) VALUES (?, ?, ?)
+		ON CONFLICT (resource, partition) DO UPDATE SET limit_value = excluded.limit_value`,
+		o.Resource, o.Partition, o.Limit,
+	)
+	return err
+}
+
+// DeleteOverride removes the limit override for a resource partition.
+func (s *SQLiteStore) DeleteOverride(ctx context.Context, resource, partition string) error {
+	_, err := s.db.ExecContext(ctx,
+		`DELETE FROM erl_limit_overrides WHERE resource = ? AND partition = ?`, resource, partition,
+	)
+	return err
+}
+
+// Overrides returns every stored limit override.
+func (s *SQLiteStore) Overrides(ctx context.Context) ([]LimitOverride, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT resource, partition, limit_value FROM erl_limit_overrides ORDER BY resource, partition`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []LimitOverride
+	for rows.Next() {
+		var o LimitOverride
+		if err := rows.Scan(&o.Resource, &o.Partition, &o.Limit); err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
+
 // Close closes the underlying SQLite database connection.
 func (s *SQLiteStore) Close() error {
 	return s.db.Close()
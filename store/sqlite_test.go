@@ -2,6 +2,10 @@ package store
 
 import (
 	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 )
@@ -100,3 +104,297 @@ func TestSQLiteStoreReset(t *testing.T) {
 		t.Errorf("after reset: got %d, want 0", got)
 	}
 }
+
+func TestSQLiteStoreOverrides(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	ctx := context.Background()
+
+	if err := s.SetOverride(ctx, LimitOverride{Resource: "api", Partition: "acme", Limit: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetOverride(ctx, LimitOverride{Resource: "api", Partition: "acme", Limit: 20}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetOverride(ctx, LimitOverride{Resource: "api", Partition: "globex", Limit: 5}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Overrides(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []LimitOverride{
+		{Resource: "api", Partition: "acme", Limit: 20},
+		{Resource: "api", Partition: "globex", Limit: 5},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("overrides = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("override %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	if err := s.DeleteOverride(ctx, "api", "acme"); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = s.Overrides(ctx)
+	if len(got) != 1 || got[0].Partition != "globex" {
+		t.Errorf("after delete: overrides = %v", got)
+	}
+}
+
+func TestSQLiteStoreIncrementMany(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	ctx := context.Background()
+	w := Window{
+		Duration:    time.Minute,
+		BucketKey:   "2024-01-15T14:30",
+		BucketStart: time.Date(2024, 1, 15, 14, 30, 0, 0, time.UTC),
+	}
+
+	s.Increment(ctx, "child", w)
+
+	got, err := s.IncrementMany(ctx, []Increment{
+		{Key: "child", Window: w, Delta: 1},
+		{Key: "parent", Window: w, Delta: 5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0] != 2 || got[1] != 5 {
+		t.Errorf("counts = %v, want [2 5]", got)
+	}
+
+	if parent, _ := s.Get(ctx, "parent", w); parent != 5 {
+		t.Errorf("parent = %d, want 5", parent)
+	}
+}
+
+func TestSQLiteStoreMark(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	ctx := context.Background()
+	w1 := Window{
+		Duration:    time.Minute,
+		BucketKey:   "2024-01-15T14:30",
+		BucketStart: time.Date(2024, 1, 15, 14, 30, 0, 0, time.UTC),
+	}
+	w2 := Window{
+		Duration:    time.Minute,
+		BucketKey:   "2024-01-15T14:31",
+		BucketStart: time.Date(2024, 1, 15, 14, 31, 0, 0, time.UTC),
+	}
+
+	for i, tt := range []struct {
+		w    Window
+		want bool
+	}{
+		{w1, true},
+		{w1, false},
+		{w2, true},
+		{w2, false},
+	} {
+		got, err := s.Mark(ctx, "alert", tt.w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("mark %d: got %v, want %v", i+1, got, tt.want)
+		}
+	}
+}
+
+func TestSQLiteStoreGetMany(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	ctx := context.Background()
+	w1 := Window{Duration: time.Minute, BucketKey: "2024-01-15T14:30"}
+	w2 := Window{Duration: time.Minute, BucketKey: "2024-01-15T14:31"}
+
+	// More keys than one query reads, so GetMany spans several chunks.
+	var incs []Increment
+	var reads []Read
+	for i := range getManyChunk + 10 {
+		key := fmt.Sprintf("tenant-%d", i)
+		incs = append(incs, Increment{Key: key, Window: w1, Delta: int64(i)})
+		reads = append(reads, Read{Key: key, Window: w1})
+	}
+	if _, err := s.IncrementMany(ctx, incs); err != nil {
+		t.Fatal(err)
+	}
+	reads = append(reads, Read{Key: "tenant-3", Window: w2}, Read{Key: "missing", Window: w1})
+
+	got, err := s.GetMany(ctx, reads)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(reads) {
+		t.Fatalf("got %d counts, want %d", len(got), len(reads))
+	}
+	for i := range getManyChunk + 10 {
+		if got[i] != int64(i) {
+			t.Fatalf("tenant-%d: got %d, want %d", i, got[i], i)
+		}
+	}
+	if tail := got[len(got)-2:]; tail[0] != 0 || tail[1] != 0 {
+		t.Errorf("stale and missing counters = %v, want [0 0]", tail)
+	}
+}
+
+// minute returns the minute bucket starting at t.
+func minute(t time.Time) Window {
+	start := t.UTC().Truncate(time.Minute)
+	return Window{Duration: time.Minute, BucketKey: start.Format("2006-01-02T15:04"), BucketStart: start}
+}
+
+func TestSQLiteStoreHistory(t *testing.T) {
+	s, err := NewSQLiteStore(":memory:", WithHistory(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+
+	now := time.Now()
+	w1, w2, w3 := minute(now.Add(-2*time.Minute)), minute(now.Add(-time.Minute)), minute(now)
+	for _, inc := range []struct {
+		w Window
+		n int
+	}{{w1, 2}, {w2, 1}, {w3, 3}} {
+		for range inc.n {
+			if _, err := s.Increment(ctx, "stripe", inc.w); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	got, err := s.History(ctx, "stripe", w1.BucketStart, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		w     Window
+		count int64
+	}{{w1, 2}, {w2, 1}, {w3, 3}}
+	if len(got) != len(want) {
+		t.Fatalf("history = %+v, want %d buckets", got, len(want))
+	}
+	for i, b := range got {
+		if b.Key != want[i].w.BucketKey || !b.Start.Equal(want[i].w.BucketStart) ||
+			!b.End.Equal(want[i].w.BucketStart.Add(time.Minute)) || b.Count != want[i].count {
+			t.Errorf("bucket %d = %+v, want %s with %d", i, b, want[i].w.BucketKey, want[i].count)
+		}
+	}
+
+	// The range is half-open on bucket starts.
+	got, _ = s.History(ctx, "stripe", w2.BucketStart, w3.BucketStart)
+	if len(got) != 1 || got[0].Key != w2.BucketKey {
+		t.Errorf("w2 only = %+v", got)
+	}
+}
+
+func TestSQLiteStoreHistoryRetention(t *testing.T) {
+	s, err := NewSQLiteStore(":memory:", WithHistory(150*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+
+	// The recent bucket started at most two minutes ago, inside the
+	// retention period; the old one did not.
+	now := time.Now()
+	old, recent, current := minute(now.Add(-10*time.Minute)), minute(now.Add(-time.Minute)), minute(now)
+	s.Increment(ctx, "stripe", old)
+	s.Increment(ctx, "stripe", recent)
+	s.Increment(ctx, "stripe", current)
+
+	got, err := s.History(ctx, "stripe", time.Time{}, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Key != recent.BucketKey || got[1].Key != current.BucketKey {
+		t.Errorf("history = %+v, want the recent and current buckets", got)
+	}
+}
+
+func TestSQLiteStoreHistoryDisabled(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	ctx := context.Background()
+
+	now := time.Now()
+	s.Increment(ctx, "stripe", minute(now.Add(-time.Minute)))
+	s.Increment(ctx, "stripe", minute(now))
+
+	got, err := s.History(ctx, "stripe", time.Time{}, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Key != minute(now).BucketKey {
+		t.Errorf("history = %+v, want only the current bucket", got)
+	}
+}
+
+func TestSQLiteStoreUpgradesSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "erl.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The erl_counters table as created before history was added.
+	if _, err := db.Exec(`
+		CREATE TABLE erl_counters (
+			key            TEXT PRIMARY KEY,
+			count          INTEGER NOT NULL DEFAULT 0,
+			bucket_key     TEXT NOT NULL DEFAULT '',
+			window_seconds INTEGER NOT NULL DEFAULT 0
+		);
+		INSERT INTO erl_counters VALUES ('stripe', 4, '2024-01-15T14:30', 60);
+	`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	s, err := NewSQLiteStore(path, WithHistory(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	w1 := Window{Duration: time.Minute, BucketKey: "2024-01-15T14:30"}
+
+	if got, _ := s.Get(ctx, "stripe", w1); got != 4 {
+		t.Errorf("existing counter = %d, want 4", got)
+	}
+	// A bucket without recorded bounds rolls over without being archived.
+	if got, err := s.Increment(ctx, "stripe", minute(time.Now())); err != nil || got != 1 {
+		t.Errorf("after rollover = %d, %v; want 1", got, err)
+	}
+}
+
+func TestSQLiteStoreKeys(t *testing.T) {
+	s, err := NewSQLiteStore(":memory:", WithHistory(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+
+	now := time.Now()
+	for _, key := range []string{"openai", "openai:acme", "openai:acme#spend", "openai-batch", "stripe"} {
+		s.Increment(ctx, key, minute(now))
+	}
+	// A counter that is gone but has archived buckets is still listed.
+	s.Increment(ctx, "openai:globex", minute(now.Add(-time.Minute)))
+	s.Increment(ctx, "openai:globex", minute(now))
+	s.Reset(ctx, "openai:globex")
+
+	got, err := s.Keys(ctx, "openai:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(got)
+	if want := []string{"openai:acme", "openai:acme#spend", "openai:globex"}; !slices.Equal(got, want) {
+		t.Errorf("keys = %v, want %v", got, want)
+	}
+}
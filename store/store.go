@@ -8,8 +8,8 @@ import (
 // Window mirrors erl.Window so the store package doesn't import the parent.
 // Callers pass the window's duration and bucket key instead.
 type Window struct {
-	Duration   time.Duration
-	BucketKey  string
+	Duration    time.Duration
+	BucketKey   string
 	BucketStart time.Time
 }
 
@@ -28,3 +28,53 @@ type Store interface {
 	// Close releases any resources held by the store.
 	Close() error
 }
+
+// Increment describes one counter update applied by a Batcher.
+type Increment struct {
+	Key    string
+	Window Window
+	Delta  int64
+}
+
+// Batcher is implemented by stores that can update several counters in one
+// atomic operation, e.g. a resource and the quota group it draws from.
+type Batcher interface {
+	// IncrementMany adds each increment's Delta to its counter, rolling the
+	// bucket over where needed, and returns the new counts in order. Either
+	// every counter is updated or none is.
+	IncrementMany(ctx context.Context, incs []Increment) ([]int64, error)
+}
+
+// Adder is implemented by stores that support weighted increments but cannot
+// update several counters atomically. When a store implements Adder but not
+// Batcher, the limiter applies a batch one counter at a time: if an update
+// fails part way, the counters before it stay incremented.
+type Adder interface {
+	// Add adds delta to the counter for key in w's bucket, rolling the
+	// bucket over where needed, and returns the new count. A zero delta
+	// reads the counter.
+	Add(ctx context.Context, key string, w Window, delta int64) (current int64, err error)
+}
+
+// Marker is implemented by stores that can record one-off events per window
+// bucket. The limiter uses it to fire threshold alerts once per window across
+// all instances sharing the store.
+type Marker interface {
+	// Mark records key in the window bucket and reports whether this call
+	// was the first to do so.
+	Mark(ctx context.Context, key string, w Window) (first bool, err error)
+}
+
+// Read describes one counter read by a BatchGetter.
+type Read struct {
+	Key    string
+	Window Window
+}
+
+// BatchGetter is implemented by stores that can read several counters in
+// one round trip. The limiter uses it to take snapshots of every resource.
+type BatchGetter interface {
+	// GetMany returns the counter of each read in its window bucket, in
+	// order.
+	GetMany(ctx context.Context, reads []Read) ([]int64, error)
+}
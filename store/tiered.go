@@ -1,13 +1,29 @@
 package store
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"time"
+)
 
-// Compile-time interface check.
-var _ Store = (*TieredStore)(nil)
+// Compile-time interface checks.
+var (
+	_ Store             = (*TieredStore)(nil)
+	_ Batcher           = (*TieredStore)(nil)
+	_ Adder             = (*TieredStore)(nil)
+	_ Marker            = (*TieredStore)(nil)
+	_ BatchGetter       = (*TieredStore)(nil)
+	_ HistoryStore      = (*TieredStore)(nil)
+	_ KeyLister         = (*TieredStore)(nil)
+	_ CapabilityChecker = (*TieredStore)(nil)
+)
 
 // TieredStore wraps an in-memory store (fast path) with a persistent backend
 // (durable path). Writes go to both stores (write-through); reads check memory
 // first and fall back to the persistent store on a miss.
+//
+// TieredStore has the methods of Batcher, HistoryStore and KeyLister but
+// supports each only when the persistent store does; see Supports.
 type TieredStore struct {
 	memory     *MemoryStore
 	persistent Store
@@ -22,6 +38,23 @@ func NewTieredStore(persistent Store) *TieredStore {
 	}
 }
 
+// Supports reports whether the persistent store supports c, so the limiter
+// treats the tiered store as it would the persistent store alone.
+func (t *TieredStore) Supports(c Capability) bool {
+	switch c {
+	case CapBatcher:
+		_, ok := AsBatcher(t.persistent)
+		return ok
+	case CapHistoryStore:
+		_, ok := AsHistoryStore(t.persistent)
+		return ok
+	case CapKeyLister:
+		_, ok := AsKeyLister(t.persistent)
+		return ok
+	}
+	return false
+}
+
 // Increment writes through to both memory and the persistent backend.
 // The persistent store is the source of truth for the returned count.
 func (t *TieredStore) Increment(ctx context.Context, key string, w Window) (int64, error) {
@@ -37,6 +70,73 @@ func (t *TieredStore) Increment(ctx context.Context, key string, w Window) (int6
 	return count, nil
 }
 
+// Add writes a weighted increment through to both stores. The persistent
+// store must implement Adder or Batcher for deltas other than 0 and 1.
+func (t *TieredStore) Add(ctx context.Context, key string, w Window, delta int64) (int64, error) {
+	var count int64
+	var err error
+	a, adder := t.persistent.(Adder)
+	b, batches := AsBatcher(t.persistent)
+	switch {
+	case adder:
+		count, err = a.Add(ctx, key, w, delta)
+	case batches:
+		var counts []int64
+		if counts, err = b.IncrementMany(ctx, []Increment{{Key: key, Window: w, Delta: delta}}); err == nil {
+			count = counts[0]
+		}
+	case delta == 0:
+		return t.Get(ctx, key, w)
+	case delta == 1:
+		count, err = t.persistent.Increment(ctx, key, w)
+	default:
+		return 0, fmt.Errorf("erl/store: %T does not support weighted increments", t.persistent)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	t.memory.IncrementMany(ctx, []Increment{{Key: key, Window: w, Delta: delta}})
+
+	return count, nil
+}
+
+// IncrementMany writes through to both stores. The batch is atomic only when
+// the persistent store supports Batcher; otherwise the increments are
+// applied one at a time as by Add.
+func (t *TieredStore) IncrementMany(ctx context.Context, incs []Increment) ([]int64, error) {
+	b, ok := AsBatcher(t.persistent)
+	if !ok {
+		counts := make([]int64, len(incs))
+		for i, inc := range incs {
+			count, err := t.Add(ctx, inc.Key, inc.Window, inc.Delta)
+			if err != nil {
+				return nil, err
+			}
+			counts[i] = count
+		}
+		return counts, nil
+	}
+
+	counts, err := b.IncrementMany(ctx, incs)
+	if err != nil {
+		return nil, err
+	}
+
+	t.memory.IncrementMany(ctx, incs)
+
+	return counts, nil
+}
+
+// Mark records key in the persistent store when it implements Marker, so
+// marks are shared with other instances, and in memory otherwise.
+func (t *TieredStore) Mark(ctx context.Context, key string, w Window) (bool, error) {
+	if m, ok := t.persistent.(Marker); ok {
+		return m.Mark(ctx, key, w)
+	}
+	return t.memory.Mark(ctx, key, w)
+}
+
 // Get reads from memory first. On a miss (zero value), it falls back to the
 // persistent store and backfills memory.
 func (t *TieredStore) Get(ctx context.Context, key string, w Window) (int64, error) {
@@ -66,6 +166,72 @@ func (t *TieredStore) Get(ctx context.Context, key string, w Window) (int64, err
 	return count, nil
 }
 
+// GetMany reads every counter from memory and fetches the misses from the
+// persistent store in one batch when it implements BatchGetter, backfilling
+// memory as Get does.
+func (t *TieredStore) GetMany(ctx context.Context, reads []Read) ([]int64, error) {
+	out, _ := t.memory.GetMany(ctx, reads)
+
+	var misses []Read
+	var at []int
+	for i, n := range out {
+		if n == 0 {
+			misses = append(misses, reads[i])
+			at = append(at, i)
+		}
+	}
+	if len(misses) == 0 {
+		return out, nil
+	}
+
+	var counts []int64
+	if g, ok := t.persistent.(BatchGetter); ok {
+		var err error
+		if counts, err = g.GetMany(ctx, misses); err != nil {
+			return nil, err
+		}
+	} else {
+		counts = make([]int64, len(misses))
+		for i, rd := range misses {
+			count, err := t.persistent.Get(ctx, rd.Key, rd.Window)
+			if err != nil {
+				return nil, err
+			}
+			counts[i] = count
+		}
+	}
+
+	t.memory.mu.Lock()
+	defer t.memory.mu.Unlock()
+	for j, count := range counts {
+		out[at[j]] = count
+		if count > 0 {
+			t.memory.buckets[misses[j].Key] = &bucket{count: count, bucketKey: misses[j].Window.BucketKey}
+		}
+	}
+	return out, nil
+}
+
+// History reads from the persistent store, which must support
+// HistoryStore.
+func (t *TieredStore) History(ctx context.Context, key string, from, to time.Time) ([]Bucket, error) {
+	h, ok := AsHistoryStore(t.persistent)
+	if !ok {
+		return nil, fmt.Errorf("erl/store: %T does not keep history", t.persistent)
+	}
+	return h.History(ctx, key, from, to)
+}
+
+// Keys lists the keys of the persistent store, which must support
+// KeyLister.
+func (t *TieredStore) Keys(ctx context.Context, prefix string) ([]string, error) {
+	k, ok := AsKeyLister(t.persistent)
+	if !ok {
+		return nil, fmt.Errorf("erl/store: %T cannot list keys", t.persistent)
+	}
+	return k.Keys(ctx, prefix)
+}
+
 // Reset removes the counter from both stores.
 func (t *TieredStore) Reset(ctx context.Context, key string) error {
 	t.memory.Reset(ctx, key)
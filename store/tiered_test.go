@@ -134,3 +134,173 @@ func TestTieredStorePersistentFallback(t *testing.T) {
 		t.Errorf("persistent fallback: got %d, want 3", got)
 	}
 }
+
+func TestTieredStoreIncrementMany(t *testing.T) {
+	s := newTestTieredStore(t)
+	ctx := context.Background()
+	w := Window{
+		Duration:    time.Minute,
+		BucketKey:   "2024-01-15T14:30",
+		BucketStart: time.Date(2024, 1, 15, 14, 30, 0, 0, time.UTC),
+	}
+
+	s.Increment(ctx, "child", w)
+
+	got, err := s.IncrementMany(ctx, []Increment{
+		{Key: "child", Window: w, Delta: 1},
+		{Key: "parent", Window: w, Delta: 5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0] != 2 || got[1] != 5 {
+		t.Errorf("counts = %v, want [2 5]", got)
+	}
+
+	if parent, _ := s.Get(ctx, "parent", w); parent != 5 {
+		t.Errorf("parent = %d, want 5", parent)
+	}
+}
+
+func TestTieredStoreGetMany(t *testing.T) {
+	persistent, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer persistent.Close()
+
+	ctx := context.Background()
+	w := Window{Duration: time.Minute, BucketKey: "2024-01-15T14:30"}
+
+	ts1 := NewTieredStore(persistent)
+	ts1.Increment(ctx, "warm", w)
+	ts1.Increment(ctx, "cold", w)
+	ts1.Increment(ctx, "cold", w)
+
+	// A fresh tiered store only knows "warm" in memory.
+	ts2 := NewTieredStore(persistent)
+	ts2.memory.Increment(ctx, "warm", w)
+
+	got, err := ts2.GetMany(ctx, []Read{
+		{Key: "warm", Window: w},
+		{Key: "cold", Window: w},
+		{Key: "missing", Window: w},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0] != 1 || got[1] != 2 || got[2] != 0 {
+		t.Errorf("counts = %v, want [1 2 0]", got)
+	}
+
+	// The persistent count was backfilled into memory.
+	if n, _ := ts2.memory.Get(ctx, "cold", w); n != 2 {
+		t.Errorf("backfilled count = %d, want 2", n)
+	}
+}
+
+// Stores with each combination of the optional interfaces a TieredStore
+// passes through. plainStore hides them all.
+type (
+	plainStore struct{ Store }
+	batchStore struct {
+		plainStore
+		Batcher
+	}
+	historyStore struct {
+		plainStore
+		HistoryStore
+	}
+	listStore struct {
+		plainStore
+		KeyLister
+	}
+	batchHistory struct {
+		plainStore
+		Batcher
+		HistoryStore
+	}
+	batchList struct {
+		plainStore
+		Batcher
+		KeyLister
+	}
+	historyList struct {
+		plainStore
+		HistoryStore
+		KeyLister
+	}
+	fullStore struct {
+		plainStore
+		Batcher
+		HistoryStore
+		KeyLister
+	}
+)
+
+func TestTieredStoreCapabilities(t *testing.T) {
+	sqlite, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlite.Close()
+	p := plainStore{sqlite}
+
+	for _, tc := range []struct {
+		name                   string
+		persistent             Store
+		batches, history, list bool
+	}{
+		{"none", p, false, false, false},
+		{"batcher", batchStore{p, sqlite}, true, false, false},
+		{"history", historyStore{p, sqlite}, false, true, false},
+		{"lister", listStore{p, sqlite}, false, false, true},
+		{"batcher+history", batchHistory{p, sqlite, sqlite}, true, true, false},
+		{"batcher+lister", batchList{p, sqlite, sqlite}, true, false, true},
+		{"history+lister", historyList{p, sqlite, sqlite}, false, true, true},
+		{"all", fullStore{p, sqlite, sqlite, sqlite}, true, true, true},
+		{"sqlite", sqlite, true, true, true},
+		{"nested", NewTieredStore(historyStore{p, sqlite}), false, true, false},
+	} {
+		s := NewTieredStore(tc.persistent)
+		if _, ok := AsBatcher(s); ok != tc.batches {
+			t.Errorf("%s: Batcher = %v, want %v", tc.name, ok, tc.batches)
+		}
+		if _, ok := AsHistoryStore(s); ok != tc.history {
+			t.Errorf("%s: HistoryStore = %v, want %v", tc.name, ok, tc.history)
+		}
+		if _, ok := AsKeyLister(s); ok != tc.list {
+			t.Errorf("%s: KeyLister = %v, want %v", tc.name, ok, tc.list)
+		}
+
+		ctx := context.Background()
+		w := Window{Duration: time.Minute, BucketKey: "2024-01-15T14:30"}
+		if _, err := s.History(ctx, "key", time.Time{}, time.Now()); (err == nil) != tc.history {
+			t.Errorf("%s: History error = %v", tc.name, err)
+		}
+		if _, err := s.Keys(ctx, ""); (err == nil) != tc.list {
+			t.Errorf("%s: Keys error = %v", tc.name, err)
+		}
+		if _, err := s.IncrementMany(ctx, []Increment{{Key: tc.name, Window: w, Delta: 1}}); err != nil {
+			t.Errorf("%s: IncrementMany: %v", tc.name, err)
+		}
+	}
+}
+
+func TestTieredStoreAdd(t *testing.T) {
+	ctx := context.Background()
+	w := Window{Duration: time.Minute, BucketKey: "2024-01-15T14:30"}
+
+	s := NewTieredStore(NewMemoryStore())
+	if got, err := s.Add(ctx, "spend", w, 250); err != nil || got != 250 {
+		t.Errorf("add = %d, %v; want 250", got, err)
+	}
+
+	plain := NewTieredStore(plainStore{NewMemoryStore()})
+	if got, err := plain.Add(ctx, "calls", w, 1); err != nil || got != 1 {
+		t.Errorf("unit add = %d, %v; want 1", got, err)
+	}
+	if _, err := plain.Add(ctx, "spend", w, 250); err == nil {
+		t.Error("weighted add succeeded on a store without weighted increments")
+	}
+}
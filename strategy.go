@@ -11,6 +11,9 @@ const (
 	BlockWithQueue
 	// LogOnly lets the request through and calls the OnLimitReached callback.
 	LogOnly
+	// Fallback blocks like Block, but Limiter.Transport answers the request
+	// from the resource's Fallback round tripper instead of returning an error.
+	Fallback
 )
 
 func (s Strategy) String() string {
@@ -21,6 +24,8 @@ func (s Strategy) String() string {
 		return "BlockWithQueue"
 	case LogOnly:
 		return "LogOnly"
+	case Fallback:
+		return "Fallback"
 	default:
 		return "Unknown"
 	}
@@ -0,0 +1,75 @@
+package erl
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/ryhazerus/erl/store"
+)
+
+// ThresholdEvent reports that a resource's usage crossed one of its warning
+// Thresholds. It is delivered once per window for each threshold.
+type ThresholdEvent struct {
+	Resource  Resource
+	Partition string
+	Threshold float64 // fraction of the limit or budget, e.g. 0.8
+	Current   int64
+	Spent     Money
+	Budget    bool // the threshold applies to the Budget rather than the Limit
+}
+
+// warn fires the threshold callback for every threshold that c crossed when
+// its counter grew by delta calls and price spend.
+func (l *Limiter) warn(ctx context.Context, c *charge, delta int64, price Money) {
+	if l.onThreshold == nil || len(c.resource.Thresholds) == 0 {
+		return
+	}
+
+	for _, t := range c.resource.Thresholds {
+		if c.resource.countLimited() && c.resource.Limit > 0 {
+			at := int64(math.Ceil(t * float64(c.resource.Limit)))
+			if c.current-delta < at && c.current >= at {
+				l.fire(ctx, c, t, false)
+			}
+		}
+		if c.resource.Budget > 0 {
+			at := Money(math.Ceil(t * float64(c.resource.Budget)))
+			if c.spent-price < at && c.spent >= at {
+				l.fire(ctx, c, t, true)
+			}
+		}
+	}
+}
+
+// fire delivers a threshold event unless another caller already did so for
+// this window.
+func (l *Limiter) fire(ctx context.Context, c *charge, t float64, budget bool) {
+	key := fmt.Sprintf("%s#warn:%g", c.key(), t)
+	if budget {
+		key = fmt.Sprintf("%s#warn:%g", spendKey(c.key()), t)
+	}
+
+	// If the store cannot be reached, err on the side of a duplicate alert.
+	if first, err := l.marker().Mark(ctx, key, c.window); err == nil && !first {
+		return
+	}
+
+	l.onThreshold(ThresholdEvent{
+		Resource:  c.resource,
+		Partition: c.partition,
+		Threshold: t,
+		Current:   c.current,
+		Spent:     c.spent,
+		Budget:    budget,
+	})
+}
+
+// marker returns the store used to de-duplicate threshold alerts: the
+// limiter's store if it supports marks, or a process-local fallback.
+func (l *Limiter) marker() store.Marker {
+	if m, ok := l.store.(store.Marker); ok {
+		return m
+	}
+	return l.local
+}
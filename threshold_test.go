@@ -0,0 +1,97 @@
+package erl
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ryhazerus/erl/store"
+)
+
+func TestLimiterThresholds(t *testing.T) {
+	var events []ThresholdEvent
+	l := New(WithOnThreshold(func(e ThresholdEvent) {
+		events = append(events, e)
+	}))
+	l.Register(Resource{
+		Name:       "warn-api",
+		Pattern:    "api.warn.com/*",
+		Limit:      10,
+		Window:     PerMinute,
+		Strategy:   LogOnly,
+		Thresholds: []float64{0.5, 0.8},
+	})
+
+	ctx := context.Background()
+	for i := 0; i < 20; i++ {
+		l.Check(ctx, "https://api.warn.com/v1")
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("events = %d, want 2", len(events))
+	}
+	if events[0].Threshold != 0.5 || events[0].Current != 5 {
+		t.Errorf("first event = %v at %d, want 0.5 at 5", events[0].Threshold, events[0].Current)
+	}
+	if events[1].Threshold != 0.8 || events[1].Current != 8 {
+		t.Errorf("second event = %v at %d, want 0.8 at 8", events[1].Threshold, events[1].Current)
+	}
+}
+
+func TestLimiterThresholdsSharedStore(t *testing.T) {
+	s, err := store.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	var fired int
+	r := Resource{
+		Name:       "shared-api",
+		Pattern:    "api.shared.com/*",
+		Limit:      4,
+		Window:     PerMinute,
+		Thresholds: []float64{0.5},
+	}
+
+	// Two instances sharing a store; the crossing call is counted once,
+	// and a reset that re-crosses the threshold is de-duplicated.
+	a := New(WithStore(s), WithOnThreshold(func(ThresholdEvent) { fired++ }))
+	b := New(WithStore(s), WithOnThreshold(func(ThresholdEvent) { fired++ }))
+	a.Register(r)
+	b.Register(r)
+
+	ctx := context.Background()
+	a.Check(ctx, "https://api.shared.com/")
+	b.Check(ctx, "https://api.shared.com/")
+	a.ResetUsage(ctx, "shared-api")
+	b.Check(ctx, "https://api.shared.com/")
+	a.Check(ctx, "https://api.shared.com/")
+
+	if fired != 1 {
+		t.Errorf("fired = %d, want 1", fired)
+	}
+}
+
+func TestLimiterBudgetThreshold(t *testing.T) {
+	var events []ThresholdEvent
+	l := New(WithOnThreshold(func(e ThresholdEvent) {
+		events = append(events, e)
+	}))
+	l.Register(Resource{
+		Name:       "paid-api",
+		Pattern:    "api.paid.com/*",
+		Window:     PerDay,
+		Budget:     Units(1),
+		Price:      Units(0.3),
+		Thresholds: []float64{0.8},
+	})
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		l.Check(ctx, "https://api.paid.com/")
+	}
+
+	if len(events) != 1 || !events[0].Budget || events[0].Spent != Units(0.9) {
+		t.Errorf("events = %+v, want one budget event at 0.900000", events)
+	}
+}
@@ -1,6 +1,10 @@
 package erl
 
-import "net/http"
+import (
+	"errors"
+	"net/http"
+	"time"
+)
 
 // transport implements http.RoundTripper and checks rate limits before
 // forwarding requests to the underlying transport.
@@ -10,8 +14,110 @@ type transport struct {
 }
 
 func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
-	if err := t.limiter.Check(req.Context(), req.URL.String()); err != nil {
+	r, st, partition, ok := t.limiter.route(req)
+	if !ok {
+		return t.forward(req, r, nil, "")
+	}
+	req = withPartition(req, partition)
+
+	var key string
+	if st.cache != nil {
+		key = cacheKey(partition, req)
+		if resp := st.cache.get(key, req, time.Now()); resp != nil {
+			st.cacheHits.Add(1)
+			return resp, nil
+		}
+	}
+
+	if st.flights != nil && coalescable(req) {
+		return st.flights.do(flightKey(partition, req), req, func(req *http.Request) (*http.Response, error) {
+			return t.forward(req, r, st, key)
+		})
+	}
+	return t.forward(req, r, st, key)
+}
+
+// forward checks the request against the limiter and sends it upstream,
+// caching and recording the response as configured for the resource r. st
+// is nil when no resource matched.
+func (t *transport) forward(req *http.Request, r Resource, st *resourceState, key string) (*http.Response, error) {
+	var b *breaker
+	var probe bool
+	if st != nil && st.breaker != nil {
+		b = st.breaker
+		var err error
+		if probe, err = t.limiter.breakerAllow(req.Context(), r, b); err != nil {
+			return t.fallback(req, r, err)
+		}
+	}
+
+	charges, err := t.limiter.check(req.Context(), req.URL.String(), req)
+	if err != nil {
+		if b != nil {
+			t.limiter.breakerRelease(b, probe)
+		}
+		return t.fallback(req, r, err)
+	}
+
+	requestTime := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	if b != nil {
+		t.limiter.breakerRecord(req.Context(), r, b, probe, failed(resp, err))
+	}
+	if err != nil {
 		return nil, err
 	}
-	return t.base.RoundTrip(req)
+
+	if st != nil && st.cache != nil {
+		if err := st.cache.store(key, req, resp, requestTime, time.Now()); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+
+	for _, rec := range recorders(charges) {
+		if err := rec.Record(req, resp); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+
+	// The upstream call has already been made, so a failure to record its
+	// price must not discard the response.
+	_ = t.limiter.settle(req.Context(), charges, req, resp)
+
+	return resp, nil
+}
+
+// fallback serves a request to the matched resource r from r's Fallback
+// round tripper when r has the Fallback strategy and the request was blocked
+// by a limit or circuit breaker anywhere in r's chain, including a quota
+// group's. Other errors are returned as-is.
+func (t *transport) fallback(req *http.Request, r Resource, err error) (*http.Response, error) {
+	var limErr *LimitExceededError
+	var openErr *CircuitOpenError
+	if !errors.As(err, &limErr) && !errors.As(err, &openErr) {
+		return nil, err
+	}
+	if r.Strategy != Fallback || r.Fallback == nil {
+		return nil, err
+	}
+
+	resp, ferr := r.Fallback.RoundTrip(req)
+	if ferr != nil {
+		return nil, errors.Join(err, ferr)
+	}
+	return resp, nil
+}
+
+// recorders returns the ResponseRecorder fallbacks of the resources charged
+// for a request.
+func recorders(charges []charge) []ResponseRecorder {
+	var out []ResponseRecorder
+	for i := range charges {
+		if rec, ok := charges[i].resource.Fallback.(ResponseRecorder); ok {
+			out = append(out, rec)
+		}
+	}
+	return out
 }
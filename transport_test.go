@@ -1,9 +1,11 @@
 package erl
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
 )
 
@@ -81,3 +83,88 @@ func TestTransportBlocksWhenLimitExceeded(t *testing.T) {
 		}
 	}
 }
+
+func TestTransportPartitionFromHeader(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	l := New()
+	l.Register(Resource{
+		Name:      "keyed-server",
+		Pattern:   "*",
+		Limit:     1,
+		Window:    PerMinute,
+		Strategy:  Block,
+		Partition: PartitionFromHeaderHash("Authorization"),
+	})
+
+	client := &http.Client{
+		Transport: l.Transport(nil),
+	}
+
+	get := func(key string) error {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/test", nil)
+		req.Header.Set("Authorization", key)
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	if err := get("Bearer one"); err != nil {
+		t.Fatalf("key one: %v", err)
+	}
+	if err := get("Bearer two"); err != nil {
+		t.Fatalf("key two: %v", err)
+	}
+	if err := get("Bearer one"); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("key one again: expected ErrLimitExceeded, got %v", err)
+	}
+}
+
+func TestTransportPriceFunc(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Cost", "0.05")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	l := New()
+	l.Register(Resource{
+		Name:     "metered-server",
+		Pattern:  "*",
+		Window:   PerMinute,
+		Strategy: Block,
+		Budget:   Units(0.10),
+		PriceFunc: func(req *http.Request, resp *http.Response) Money {
+			cost, _ := strconv.ParseFloat(resp.Header.Get("X-Cost"), 64)
+			return Units(cost)
+		},
+	})
+
+	client := &http.Client{
+		Transport: l.Transport(nil),
+	}
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(srv.URL + "/test")
+		if err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+		resp.Body.Close()
+	}
+
+	// The budget is used up by the settled prices of earlier responses.
+	if _, err := client.Get(srv.URL + "/test"); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("expected ErrLimitExceeded, got %v", err)
+	}
+
+	statuses, _ := l.Snapshot(context.Background())
+	if statuses[0].Spent != Units(0.10) {
+		t.Errorf("spent = %s, want 0.100000", statuses[0].Spent)
+	}
+}
@@ -3,6 +3,8 @@ package erl
 import (
 	"fmt"
 	"time"
+
+	"github.com/ryhazerus/erl/store"
 )
 
 // Window represents a time window for rate limit tracking.
@@ -70,6 +72,15 @@ func (w Window) BucketStart(t time.Time) time.Time {
 	}
 }
 
+// storeWindow describes the bucket containing t for the store package.
+func (w Window) storeWindow(t time.Time) store.Window {
+	return store.Window{
+		Duration:    w.Duration(),
+		BucketKey:   w.BucketKey(t),
+		BucketStart: w.BucketStart(t),
+	}
+}
+
 func (w Window) String() string {
 	switch w {
 	case PerMinute: